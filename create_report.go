@@ -3,10 +3,8 @@ package main
 import (
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
 	"os"
-	"path/filepath"
-	"strings"
+	"text/tabwriter"
 )
 
 // ReportRow is information about running docrun on a single markdown file
@@ -16,6 +14,12 @@ type ReportRow struct {
 	SuccessTrivial int
 	FailureOther   int
 	FailureMissing int
+	FailureOrphan  int
+	Unannotated    int
+	Ignored        int
+	Skipped        int
+	ExpectedFail   int
+	Checked        int
 }
 
 // FullReport is a full collection of docrun results
@@ -23,42 +27,52 @@ type FullReport struct {
 	Rows []ReportRow
 }
 
-func walkRepository(report *FullReport, repo string) {
-	rootPath := filepath.Join(os.Getenv("GOPATH"), "src")
-	err := filepath.Walk(filepath.Join(rootPath, repo),
-		func(path string, info os.FileInfo, err error) error {
-			if strings.HasSuffix(path, ".md") {
-				// Found a markdown file. Run docrun over it.
-				res := docGetResults(path)
-				if !res.Empty() {
-					// If there was a non-empty result, add it to report.
-					row := ReportRow{
-						Path:           path[len(rootPath)+1:],
-						SuccessOther:   res.CountSuccess - res.CountTrivial,
-						SuccessTrivial: res.CountTrivial,
-						FailureOther:   res.CountTotal - res.CountSuccess - res.CountMissing,
-						FailureMissing: res.CountMissing,
-					}
-					report.Rows = append(report.Rows, row)
-				}
-			}
-			return nil
-		})
+func walkProject(report *FullReport) error {
+	docs, err := runner.Project.Documents()
 	if err != nil {
-		panic(err)
+		return err
 	}
+	for _, path := range docs {
+		// Found a markdown file. Run docrun over it.
+		res := docGetResults(path)
+		if !res.Empty() {
+			// If there was a non-empty result, add it to report.
+			row := ReportRow{
+				Path:           runner.Project.ReportPath(path),
+				SuccessOther:   res.CountSuccess - res.CountTrivial,
+				SuccessTrivial: res.CountTrivial,
+				FailureOther:   res.Failures() - res.CountMissing,
+				FailureMissing: res.CountMissing,
+				FailureOrphan:  res.CountOrphaned,
+				Unannotated:    res.CountUnannotated,
+				Ignored:        res.CountIgnored,
+				Skipped:        res.CountSkipped + res.CountDeselected,
+				ExpectedFail:   res.CountXFail,
+				Checked:        res.CountChecked,
+			}
+			report.Rows = append(report.Rows, row)
+		}
+	}
+	return nil
 }
 
 func createReport() {
 	report := FullReport{Rows: []ReportRow{}}
-	data, _ := ioutil.ReadFile("manifest.txt")
-	text := string(data)
-	repos := strings.Split(text, "\n")
-	for _, repo := range repos {
-		if repo == "" {
-			continue
+	if err := walkProject(&report); err != nil {
+		fmt.Printf("Error creating report: %s\n", err)
+		os.Exit(1)
+	}
+	if runner.Project.Output == "text" {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintf(w, "PATH\tPASS\tTRIVIAL\tFAIL\tMISSING\tORPHAN\tUNANNOTATED\tIGNORED\tSKIP\tXFAIL\tCHECKED\n")
+		for _, row := range report.Rows {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\t%d\n", row.Path, row.SuccessOther,
+				row.SuccessTrivial, row.FailureOther, row.FailureMissing, row.FailureOrphan,
+				row.Unannotated, row.Ignored, row.Skipped, row.ExpectedFail,
+				row.Checked)
 		}
-		walkRepository(&report, repo)
+		w.Flush()
+		return
 	}
 	obj, _ := json.MarshalIndent(report, "", " ")
 	fmt.Printf("%s\n", string(obj))
@@ -1,14 +1,10 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
-	"io"
-	"io/ioutil"
 	"os"
 
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/ast"
-	"github.com/gomarkdown/markdown/html"
 	golog "github.com/ipfs/go-log"
 	"github.com/qri-io/docrun/framework"
 )
@@ -16,16 +12,6 @@ import (
 // The actual DocRun processor, given each node as they are parsed.
 var runner framework.DocRunner
 
-// renderHook is called by markdown parser for each ast node.
-func renderHook(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
-	// Only process nodes when they open up.
-	if entering {
-		runner.AddNode(node)
-	}
-	// Tell the parser to continue walking the ast normally.
-	return ast.GoToNext, false
-}
-
 func setLogLevel(logLevel int) {
 	// Assign log level to the logger.
 	if logLevel == 1 {
@@ -35,28 +21,42 @@ func setLogLevel(logLevel int) {
 	}
 }
 
+// runOutput is the result of running docrun on a single file, for json output
+type runOutput struct {
+	Path     string
+	Results  framework.RunResults
+	Errors   []string
+	Warnings []string
+}
+
 func createRunResults(path string) {
-	// This library converts markdown to html, with a hook for parsed nodes. We ignore the html
-	// output, and only care about the ast nodes while parsing is happening.
-	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: renderHook,
-	}
-	renderer := html.NewRenderer(opts)
-	md, err := ioutil.ReadFile(path)
+	// Parse markdown to collect and run test cases.
+	err := runner.RunFile(path)
 	if os.IsNotExist(err) {
 		fmt.Printf("File not found: \"%s\"\n", path)
 		os.Exit(1)
 	} else if err != nil {
 		panic(err)
 	}
-	// Parse markdown to collect and run test cases.
-	_ = markdown.ToHTML([]byte(md), nil, renderer)
 }
 
 func docAnalyze(path string) {
 	runner.Init()
 	createRunResults(path)
+	if runner.Project.Output == "json" {
+		out := runOutput{Path: path, Results: runner.GetResults(), Errors: []string{},
+			Warnings: []string{}}
+		for _, err := range runner.Errs {
+			out.Errors = append(out.Errors, err.Error())
+		}
+		for _, err := range runner.Warnings {
+			out.Warnings = append(out.Warnings, err.Error())
+		}
+		obj, _ := json.MarshalIndent(out, "", " ")
+		fmt.Printf("%s\n", string(obj))
+		return
+	}
+	runner.ShowWarnings()
 	if runner.HasError() {
 		runner.ShowErrors()
 	}
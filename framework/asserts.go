@@ -0,0 +1,169 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+	"github.com/qri-io/qri/startf/context"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// assertDetails is an expression to evaluate after the Call, and its expected result
+type assertDetails struct {
+	Actual string
+	Expect interface{}
+	// Whether Expect was given, since `expect: null` leaves it nil
+	hasExpect bool
+}
+
+// checkRequired makes sure the assert has both an expression and its expected result.
+func (a *assertDetails) checkRequired(collector *fill.ErrorCollector) {
+	if a.Actual == "" {
+		collector.Add(fmt.Errorf("field \"actual\" is required"))
+	}
+	if !a.hasExpect {
+		collector.Add(fmt.Errorf("field \"expect\" is required"))
+	}
+}
+
+// markExpects records which of the test's results were given an expect, from the raw fields of
+// the test, since fill.Struct skips null values.
+func (t *testDetails) markExpects(raw interface{}) {
+	fields := toStringMap(raw)
+	t.hasExpect = hasField(fields, "expect")
+	asserts, _ := fields["asserts"].([]interface{})
+	for i, assert := range asserts {
+		if i < len(t.Asserts) && t.Asserts[i] != nil {
+			t.Asserts[i].hasExpect = hasField(toStringMap(assert), "expect")
+		}
+	}
+}
+
+// hasField returns whether the fields have a key, matched case-insensitively like fill.Struct.
+func hasField(fields map[string]interface{}, name string) bool {
+	for key := range fields {
+		if strings.EqualFold(key, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runSetup runs the Setup, in a scope of its own so that it won't modify the environment. Lines
+// of the form `ctx.download = <expression>` assign ctx.download, which can't be done by running
+// them since ctx is read-only. It returns whether ctx.download was assigned.
+func runSetup(thread *starlark.Thread, setup string, ctx *context.Context,
+	environment starlark.StringDict) (bool, error) {
+	scope := starlark.StringDict{}
+	for k, v := range environment {
+		scope[k] = v
+	}
+	lines := []string{}
+	flush := func() error {
+		if len(lines) == 0 {
+			return nil
+		}
+		globals, err := starlark.ExecFile(thread, "", strings.Join(lines, "\n"), scope)
+		lines = nil
+		for k, v := range globals {
+			scope[k] = v
+		}
+		return err
+	}
+	downloaded := false
+	for _, line := range strings.Split(setup, "\n") {
+		if !strings.HasPrefix(line, downloadSetupPrefix) {
+			lines = append(lines, line)
+			continue
+		}
+		if err := flush(); err != nil {
+			return downloaded, err
+		}
+		result, err := starlark.Eval(thread, "", strings.TrimPrefix(line, downloadSetupPrefix), scope)
+		if err != nil {
+			return downloaded, err
+		}
+		ctx.SetResult("download", result)
+		scope["ctx"] = ctx.Struct()
+		downloaded = true
+	}
+	return downloaded, flush()
+}
+
+// evalStarlark runs starlark code and returns its value. The code is either a single
+// expression, or statements that may end with an expression giving the value, which is None
+// otherwise. Definitions made by the statements are added to the environment, for later steps.
+func evalStarlark(thread *starlark.Thread, source string, environment starlark.StringDict) (starlark.Value, error) {
+	file, err := syntax.Parse("", source, 0)
+	if err != nil {
+		return nil, err
+	}
+	var expr syntax.Expr
+	if n := len(file.Stmts); n > 0 {
+		if last, ok := file.Stmts[n-1].(*syntax.ExprStmt); ok {
+			expr = last.X
+			file.Stmts = file.Stmts[:n-1]
+		}
+	}
+	if len(file.Stmts) > 0 {
+		program, err := starlark.FileProgram(file, environment.Has)
+		if err != nil {
+			return nil, err
+		}
+		globals, err := program.Init(thread, environment)
+		if err != nil {
+			return nil, err
+		}
+		globals.Freeze()
+		for k, v := range globals {
+			environment[k] = v
+		}
+	}
+	if expr == nil {
+		return starlark.None, nil
+	}
+	return starlark.EvalExpr(thread, expr, environment)
+}
+
+// checkAssert evaluates an Actual and compares its result against the expected result. The
+// special Actual "stdout.get()" is what the Call printed.
+func checkAssert(thread *starlark.Thread, actualSource string, expect interface{},
+	environment starlark.StringDict, stdoutPath string) error {
+	var actual interface{}
+	if actualSource == "stdout.get()" {
+		// Get what was written to stdout.
+		stdoutText, _ := ioutil.ReadFile(stdoutPath)
+		actual = strings.TrimSpace(string(stdoutText))
+	} else {
+		// TODO(dlong): Validate that this is an expression (should not have side-effects)
+		accessed, err := evalStarlark(thread, actualSource, environment)
+		if err != nil {
+			return fmt.Errorf("during Actual: %s", err.Error())
+		}
+		// Parse the results from Actual into a native data structure, where None is null.
+		if accessed != starlark.None {
+			resultStr := accessed.String()
+			err = json.Unmarshal([]byte(resultStr), &actual)
+			if err != nil {
+				return fmt.Errorf("parsing \"%s\": %s", resultStr, err.Error())
+			}
+		}
+	}
+
+	actualString := fmt.Sprintf("%s", actual)
+	expectString := fmt.Sprintf("%s", expect)
+
+	// Compare actual results against the expected results, fail if different.
+	if !reflect.DeepEqual(actualString, expectString) {
+		tmpl := `test case failure
+  actual: %s
+  expect: %s`
+		return fmt.Errorf(tmpl, actualString, expectString)
+	}
+	return nil
+}
@@ -0,0 +1,193 @@
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+	"gopkg.in/yaml.v2"
+)
+
+// cassette is a file of http responses, recorded from the network so that examples can replay
+// realistic data offline. Each response is a route, the same as those given to a WebProxy.
+type cassette struct {
+	Routes []*routeDetails
+}
+
+// unrecordedHeaders are response headers that aren't recorded, since they would change each time
+// a cassette is recorded, or no longer be accurate if it's edited
+var unrecordedHeaders = []string{"Date", "Content-Length"}
+
+// prepareWebProxy finds the cassette file of a WebProxy, and sets whether it is being recorded. A
+// cassette that is being recorded doesn't need to exist yet.
+func (f *DocRunner) prepareWebProxy(proxy *proxyDetails) error {
+	if proxy == nil || proxy.Cassette == "" {
+		return nil
+	}
+	proxy.record = f.Record
+	path, err := f.project().ResolveFixture(f.docDir(), proxy.Cassette)
+	if err != nil {
+		if !f.Record {
+			return err
+		}
+		path = filepath.Join(f.docDir(), proxy.Cassette)
+	}
+	proxy.cassettePath = path
+	return nil
+}
+
+// transport returns what answers the http requests of a test: a local server for the proxy's
+// routes, followed by those of its cassette, or the network when the cassette is being
+// recorded. The server lasts until close is called.
+func (p *proxyDetails) transport() (http.RoundTripper, error) {
+	if p.server == nil {
+		routes := p.routes()
+		if p.cassettePath != "" && !p.record {
+			recorded, err := loadCassette(p.cassettePath)
+			if err != nil {
+				return nil, err
+			}
+			routes = append(routes, (&proxyDetails{Routes: recorded}).routes()...)
+		}
+		p.server = newProxyServer(routes)
+	}
+	if p.record {
+		return &recordingTransport{local: p.server, path: p.cassettePath}, nil
+	}
+	return p.server, nil
+}
+
+// close stops the proxy's server, if it was started
+func (p *proxyDetails) close() {
+	if p.server != nil {
+		p.server.Close()
+		p.server = nil
+	}
+}
+
+// loadCassette reads the routes of a cassette file.
+func loadCassette(path string) ([]*routeDetails, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = yaml.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: %s", path, err)
+	}
+	err = checkFields(fields, reflect.TypeOf(cassette{}))
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: %s", path, err)
+	}
+	c := cassette{}
+	err = fill.Struct(fields, &c)
+	if err != nil {
+		return nil, fmt.Errorf("cassette %s: %s", path, err)
+	}
+	collector := fill.NewErrorCollector()
+	for i, route := range c.Routes {
+		collector.PushField(fmt.Sprintf("routes.%d", i))
+		route.checkRequired(collector)
+		collector.PopField()
+	}
+	if err := collector.AsSingleError(); err != nil {
+		return nil, fmt.Errorf("cassette %s: %s", path, err)
+	}
+	return c.Routes, nil
+}
+
+// saveCassette writes routes to a cassette file, with their fields in a readable order.
+func saveCassette(path string, routes []*routeDetails) error {
+	list := make([]yaml.MapSlice, len(routes))
+	for i, route := range routes {
+		item := yaml.MapSlice{
+			{Key: "method", Value: route.Method},
+			{Key: "url", Value: route.URL},
+			{Key: "status", Value: route.Status},
+		}
+		if len(route.Headers) > 0 {
+			item = append(item, yaml.MapItem{Key: "headers", Value: route.Headers})
+		}
+		item = append(item, yaml.MapItem{Key: "response", Value: route.Response})
+		list[i] = item
+	}
+	data, err := yaml.Marshal(yaml.MapSlice{{Key: "routes", Value: list}})
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+// recordingTransport sends http requests to the network, recording each response into a cassette
+// file. Requests that the proxy's own routes answer aren't sent or recorded.
+type recordingTransport struct {
+	local *proxyServer
+	path  string
+}
+
+// RoundTrip sends the request, and adds its response to the cassette, replacing any response
+// already recorded for the same method and URL
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.local.match(req) != nil {
+		return t.local.RoundTrip(req)
+	}
+	res, err := http.DefaultTransport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorded := &routeDetails{
+		Method:   req.Method,
+		URL:      req.URL.String(),
+		Status:   res.StatusCode,
+		Response: string(body),
+	}
+	for key, vals := range res.Header {
+		if containsString(unrecordedHeaders, key) {
+			continue
+		}
+		if recorded.Headers == nil {
+			recorded.Headers = map[string]string{}
+		}
+		recorded.Headers[key] = strings.Join(vals, ",")
+	}
+
+	routes := []*routeDetails{}
+	if _, err := os.Stat(t.path); err == nil {
+		routes, err = loadCassette(t.path)
+		if err != nil {
+			return nil, err
+		}
+	}
+	replaced := false
+	for i, route := range routes {
+		if strings.EqualFold(route.Method, recorded.Method) && route.URL == recorded.URL {
+			routes[i] = recorded
+			replaced = true
+		}
+	}
+	if !replaced {
+		routes = append(routes, recorded)
+	}
+	if err := saveCassette(t.path, routes); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
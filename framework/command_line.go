@@ -17,3 +17,24 @@ func NewCommandLineRunner() *CommandLineRunner {
 func (r *CommandLineRunner) Run(details *commandDetails, sourceCode string) error {
 	return fmt.Errorf("IMPLEMENT ME")
 }
+
+// commandLinePlugin runs command cases, expecting CaseContext.Config to be *commandDetails
+type commandLinePlugin struct {
+	runner *CommandLineRunner
+}
+
+// Prepare does nothing yet
+func (p *commandLinePlugin) Prepare(c *CaseContext) error {
+	return nil
+}
+
+// Run executes the command
+// TODO(dlong): Implementation is only a stub currently.
+func (p *commandLinePlugin) Run(c *CaseContext) error {
+	return p.runner.Run(c.Config.(*commandDetails), c.Source)
+}
+
+// Cleanup does nothing yet
+func (p *commandLinePlugin) Cleanup(c *CaseContext) error {
+	return nil
+}
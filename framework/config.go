@@ -0,0 +1,300 @@
+package framework
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/qri-io/qri/base/fill"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigFilename is the name of the project configuration file, which is discovered by looking
+// upward from the working directory.
+const ConfigFilename = ".docrun.yaml"
+
+// ProjectConfig is configuration shared by every document in a project, so that every engineer
+// and CI job gets identical behavior.
+type ProjectConfig struct {
+	// Directories to search for markdown files, relative to the configuration file. Environment
+	// variables such as $GOPATH are expanded.
+	Roots []string
+	// Globs that markdown files must match to be run, relative to the configuration file
+	Include []string
+	// Globs for markdown files that should not be run, even if included
+	Exclude []string
+	// Language for code blocks that don't specify one
+	Lang string
+	// Additional names for code fence languages, mapped to the name of a known language
+	LangAliases map[string]string `json:"lang_aliases"`
+	// Additional names for filltypes, mapped to the name of a known filltype
+	Filltypes map[string]string
+	// Directories to search for fixture files, relative to the configuration file
+	FixtureDirs []string `json:"fixture_dirs"`
+	// Datasets that tests can load, by reference, see documentDetails.Datasets
+	Datasets map[string]interface{}
+	// Starlark modules that tests can load, or not
+	Modules *moduleDetails
+	// Format of results: "text" or "json". If not set, `run` uses text and `report` uses json.
+	Output string
+	// Longest amount of time a single case may run for, such as "10s"
+	Timeout string
+	// What to do with code blocks that have no fixture, see documentDetails.Unannotated
+	Unannotated string
+	// Actions for code blocks that have no fixture, depending upon their language
+	UnannotatedRules []unannotatedRule `json:"unannotated_rules"`
+
+	// Directory containing the configuration file. Not read from the file itself.
+	dir string
+}
+
+// outputFormats are the allowed values for ProjectConfig.Output
+var outputFormats = []string{"text", "json"}
+
+// DefaultProjectConfig returns the configuration used when there's no configuration file, which
+// runs every markdown file under the working directory.
+func DefaultProjectConfig() *ProjectConfig {
+	return &ProjectConfig{
+		Roots:   []string{"."},
+		Include: []string{"**/*.md"},
+		dir:     ".",
+	}
+}
+
+// FindProjectConfig looks for a configuration file in the directory, and each of its parents,
+// returning the path of the first one found, or the empty string if there isn't one.
+func FindProjectConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for {
+		candidate := filepath.Join(dir, ConfigFilename)
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+// LoadProjectConfig reads a configuration file. Fields it doesn't set keep their defaults.
+func LoadProjectConfig(filename string) (*ProjectConfig, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	err = yaml.Unmarshal(data, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	err = checkFields(fields, reflect.TypeOf(ProjectConfig{}))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	config := DefaultProjectConfig()
+	err = fill.Struct(fields, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	config.dir = filepath.Dir(filename)
+	err = config.validate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", filename, err)
+	}
+	return config, nil
+}
+
+// validate checks that fields with a fixed set of values are valid.
+func (c *ProjectConfig) validate() error {
+	if c.Output != "" && !containsString(outputFormats, c.Output) {
+		return fmt.Errorf("unknown output \"%s\", expected one of: %s", c.Output,
+			strings.Join(outputFormats, ", "))
+	}
+	if c.Timeout != "" {
+		if _, err := time.ParseDuration(c.Timeout); err != nil {
+			return fmt.Errorf("invalid timeout \"%s\": %s", c.Timeout, err)
+		}
+	}
+	if err := checkUnannotated(c.Unannotated, c.UnannotatedRules); err != nil {
+		return err
+	}
+	if err := checkLangAliases(c.LangAliases); err != nil {
+		return err
+	}
+	if err := checkDatasetRefs(c.Datasets); err != nil {
+		return err
+	}
+	if err := c.Modules.checkModules(); err != nil {
+		return err
+	}
+	for name, target := range c.Filltypes {
+		if !isFilltype(target) {
+			return fmt.Errorf("filltype \"%s\" refers to unknown filltype \"%s\"", name, target)
+		}
+	}
+	return nil
+}
+
+// Dir returns the directory containing the configuration file.
+func (c *ProjectConfig) Dir() string {
+	return c.dir
+}
+
+// resolve converts a path relative to the configuration file into a usable path.
+func (c *ProjectConfig) resolve(name string) string {
+	name = os.ExpandEnv(name)
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(c.dir, name)
+}
+
+// RelPath returns a path relative to the configuration file, for displaying and glob matching.
+func (c *ProjectConfig) RelPath(name string) string {
+	rel, err := filepath.Rel(c.dir, name)
+	if err != nil {
+		return name
+	}
+	return filepath.ToSlash(rel)
+}
+
+// ReportPath returns the name of a document in reports: relative to $GOPATH/src if it's there,
+// otherwise relative to the root it was found under.
+func (c *ProjectConfig) ReportPath(name string) string {
+	for _, gopath := range filepath.SplitList(os.Getenv("GOPATH")) {
+		if rel, ok := relInside(filepath.Join(gopath, "src"), name); ok {
+			return rel
+		}
+	}
+	for _, root := range c.Roots {
+		if rel, ok := relInside(c.resolve(root), name); ok {
+			return rel
+		}
+	}
+	return c.RelPath(name)
+}
+
+// relInside returns a path relative to a directory, if the path is inside of it.
+func relInside(dir, name string) (string, bool) {
+	rel, err := filepath.Rel(dir, name)
+	if err != nil {
+		return "", false
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return "", false
+	}
+	return rel, true
+}
+
+// Documents returns the markdown files under the project's roots that match its include globs,
+// and none of its exclude globs. Roots that don't exist, such as repositories that aren't checked
+// out, are skipped with a warning.
+func (c *ProjectConfig) Documents() ([]string, error) {
+	found := map[string]bool{}
+	for _, root := range c.Roots {
+		dir := c.resolve(root)
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			log.Warningf("skipping root %s, %s does not exist", root, dir)
+			continue
+		}
+		err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.IsDir() && c.Matches(name) {
+				found[name] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	docs := make([]string, 0, len(found))
+	for name := range found {
+		docs = append(docs, name)
+	}
+	sort.Strings(docs)
+	return docs, nil
+}
+
+// Matches returns whether a file is included, and not excluded, by the project's globs.
+func (c *ProjectConfig) Matches(name string) bool {
+	rel := c.RelPath(name)
+	included := false
+	for _, pattern := range c.Include {
+		if matchGlob(pattern, rel) {
+			included = true
+			break
+		}
+	}
+	if !included {
+		return false
+	}
+	for _, pattern := range c.Exclude {
+		if matchGlob(pattern, rel) {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolveFixture finds a fixture file by looking in the directory of the document that refers
+// to it, followed by each of the project's fixture directories.
+func (c *ProjectConfig) ResolveFixture(docDir, name string) (string, error) {
+	if filepath.IsAbs(name) {
+		return name, nil
+	}
+	candidates := []string{filepath.Join(docDir, name)}
+	for _, dir := range c.FixtureDirs {
+		candidates = append(candidates, filepath.Join(c.resolve(dir), name))
+	}
+	for _, candidate := range candidates {
+		if _, err := os.Stat(candidate); err == nil {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("fixture file \"%s\" not found", name)
+}
+
+// matchGlob matches a slash-separated path against a glob, where "**" matches any number of
+// directories, and other wildcards behave like path.Match.
+func matchGlob(pattern, name string) bool {
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+// matchGlobParts matches path components against glob components.
+func matchGlobParts(pattern, parts []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			// Try to match the rest of the pattern at every remaining position.
+			for i := 0; i <= len(parts); i++ {
+				if matchGlobParts(pattern[1:], parts[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(parts) == 0 {
+			return false
+		}
+		if ok, err := path.Match(pattern[0], parts[0]); !ok || err != nil {
+			return false
+		}
+		pattern = pattern[1:]
+		parts = parts[1:]
+	}
+	return len(parts) == 0
+}
@@ -0,0 +1,124 @@
+package framework
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestLoadProjectConfig(t *testing.T) {
+	found, err := FindProjectConfig("testdata/project/docs/nested")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect, _ := filepath.Abs("testdata/project/.docrun.yaml")
+	if found != expect {
+		t.Fatalf("found config mismatch, actual: \"%s\", expect: \"%s\"", found, expect)
+	}
+	config, err := LoadProjectConfig(found)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Lang != "python" {
+		t.Errorf("Expected lang python, got \"%s\"", config.Lang)
+	}
+	docs, err := config.Documents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rels := []string{}
+	for _, doc := range docs {
+		rels = append(rels, config.RelPath(doc))
+	}
+	expectDocs := []string{"docs/nested/guide.md", "docs/readme.md"}
+	if !reflect.DeepEqual(rels, expectDocs) {
+		t.Errorf("documents mismatch, actual: %v, expect: %v", rels, expectDocs)
+	}
+
+	// The project's language and filltype names are used when running a document.
+	runner.Init()
+	runner.Project = config
+	err = runner.RunFile(docs[1])
+	runner.Project = nil
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	if runner.Results.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", runner.Results.CountSuccess)
+	}
+}
+
+func TestProjectDocumentsReportPaths(t *testing.T) {
+	gopath, err := ioutil.TempDir("", "gopath")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	repo := filepath.Join(gopath, "src", "github.com", "qri-io", "dataset")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(repo, "README.md"), []byte("# Dataset\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GOPATH", os.Getenv("GOPATH"))
+	os.Setenv("GOPATH", gopath)
+
+	dir, _ := filepath.Abs("testdata/project")
+	config := &ProjectConfig{
+		// Repositories that aren't checked out are skipped.
+		Roots:   []string{"$GOPATH/src/github.com/qri-io/dataset", "$GOPATH/src/github.com/qri-io/frontend", "docs"},
+		Include: []string{"**/*.md"},
+		dir:     dir,
+	}
+	docs, err := config.Documents()
+	if err != nil {
+		t.Fatal(err)
+	}
+	paths := []string{}
+	for _, doc := range docs {
+		paths = append(paths, config.ReportPath(doc))
+	}
+	sort.Strings(paths)
+	expect := []string{"github.com/qri-io/dataset/README.md", "nested/guide.md", "readme.md"}
+	if !reflect.DeepEqual(paths, expect) {
+		t.Errorf("report paths mismatch, actual: %v, expect: %v", paths, expect)
+	}
+}
+
+func TestLoadProjectConfigErrors(t *testing.T) {
+	_, err := LoadProjectConfig("testdata/error_config.yaml")
+	expect := "testdata/error_config.yaml: unknown field \"exclud\", did you mean \"exclude\"?"
+	if err == nil || err.Error() != expect {
+		t.Errorf("error mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		expect  bool
+	}{
+		{"**/*.md", "readme.md", true},
+		{"**/*.md", "docs/nested/readme.md", true},
+		{"**/*.md", "docs/readme.txt", false},
+		{"docs/*.md", "docs/readme.md", true},
+		{"docs/*.md", "docs/nested/readme.md", false},
+		{"**/vendor/**", "a/vendor/b/c.md", true},
+		{"**/vendor/**", "a/b/c.md", false},
+		{"../dataset/**/*.md", "../dataset/readme.md", true},
+	}
+	for i, c := range cases {
+		if got := matchGlob(c.pattern, c.name); got != c.expect {
+			t.Errorf("case %d: matchGlob(\"%s\", \"%s\") = %t, expect %t", i, c.pattern, c.name,
+				got, c.expect)
+		}
+	}
+}
@@ -8,15 +8,38 @@ type DocrunFixture struct {
 
 // docrunDetails holds all the metadata about the source code that follows it.
 type docrunDetails struct {
-	// Only one of the following three fields should be specified
+	// Only one of the following fields should be specified
 	Pass    bool
 	Test    *testDetails
 	Command *commandDetails
-	// Type of data structure to fill
-	Filltype string
+	Compile *compileDetails
+	Check   string
+	// Type of data structure to fill, whether to check the meaning of the filled structure, and
+	// whether to check that it encodes back to the same data
+	Filltype  string
+	Validate  bool
+	Roundtrip bool
+	// JSON Schema file to validate structured data against, the same as a filltype of "schema:"
+	// followed by the file's path
+	Schema string
 	// These two fields are entirely optional
 	Lang string
 	Save *saveDetails
+	// Reason to skip this case, or the reason it is expected to fail
+	Skip  string
+	Xfail string
+	// Only run cases marked "only" in this document
+	Only bool
+	// Stable identifier for this case, and tags for selecting which cases to run
+	Name string
+	Tags []string
+	// Code blocks in the same session share global definitions, as well as `ds` and `ctx`
+	Session string
+
+	// Fields for modes registered from outside the framework, see RegisterRunner
+	custom map[string]interface{}
+	// Every field as deserialized from YAML, see CaseContext.Raw
+	raw map[string]interface{}
 }
 
 // testDetails holds metadata about a test case. Expected results are recorded in this structure.
@@ -26,12 +49,67 @@ type testDetails struct {
 	Call     string
 	Actual   string
 	Expect   interface{}
+	// More expressions to check after the Call, each with its expected result
+	Asserts []*assertDetails
+	// Run after everything else, even if the test fails, to undo changes to a session
+	Teardown string
+	// Components of `ds` expected after the Call, such as meta, structure and body
+	ExpectDataset map[string]interface{} `json:"expect_dataset"`
+	// Run the code block like a qri transform: download(ctx), then transform(ds, ctx), in place of
+	// a Call
+	Lifecycle bool
+	// Value of ctx.download, as a starlark expression or a fixture file, so that the download
+	// function doesn't need to be called
+	Download     string
+	DownloadFile string `json:"download_file"`
+	// Values returned by ctx.get_config and ctx.get_secret
+	Config  map[string]interface{}
+	Secrets map[string]interface{}
+
+	// Whether Expect was given, since `expect: null` leaves it nil
+	hasExpect bool
+	// Value of ctx.download read from DownloadFile
+	downloadFixture interface{}
+	// Datasets declared by the document and project, by reference
+	datasets map[string]interface{}
+	// Restrictions on which modules can be loaded
+	modules *moduleDetails
 }
 
-// proxyDetails is used for tests that need a mock http response
+// proxyDetails is used for tests that need mock http responses. A single response can be given
+// with URL and Response, or any number with Routes, along with those recorded in a Cassette file.
 type proxyDetails struct {
 	URL      string
 	Response interface{}
+	Routes   []*routeDetails
+	Cassette string
+
+	// Path of the cassette file, once it has been found
+	cassettePath string
+	// Whether to record responses from the network into the cassette, instead of replaying them
+	record bool
+	// Server answering requests for the case being run
+	server *proxyServer
+}
+
+// routeDetails is a mock http response, and the requests it answers
+type routeDetails struct {
+	// HTTP method to match, or any method if empty
+	Method string
+	// Only one of these matches the URL of the request, without its query string: the whole URL,
+	// its beginning, or a regular expression
+	URL    string
+	Prefix string
+	Regex  string
+	// Query parameters and headers the request must have
+	Query          map[string]string
+	RequestHeaders map[string]string `json:"request_headers"`
+	// Status code of the response, 200 if not set
+	Status int
+	// Headers of the response
+	Headers map[string]string
+	// Body of the response. A string is sent as is, anything else is encoded as JSON.
+	Response interface{}
 }
 
 // saveDetails is used to save source code to a file for future commands
@@ -44,6 +122,8 @@ type saveDetails struct {
 type DocrunSource struct {
 	Code string
 	Lang string
+	// Line number in the document where the code block begins
+	Line int
 }
 
 // commandDetails holds information about commands to run
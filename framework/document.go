@@ -0,0 +1,250 @@
+package framework
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/qri-io/qri/base/fill"
+	"gopkg.in/yaml.v2"
+)
+
+// DocrunConfig is a wrapper for document-level configuration. It either appears as the first
+// docrun comment block in a document, beginning with the text "docrun-config:", or as the
+// "docrun" field of the document's YAML front matter.
+type DocrunConfig struct {
+	Config documentDetails `json:"docrun-config"`
+}
+
+// documentDetails holds defaults that are merged into each fixture of a document.
+type documentDetails struct {
+	// Language for code blocks that don't specify one
+	Lang string
+	// Additional names for code fence languages, mapped to the name of a known language
+	LangAliases map[string]string `json:"lang_aliases"`
+	// Setup for tests that don't have their own
+	Setup string
+	// Mock http responses for tests that don't have their own
+	WebProxy *proxyDetails
+	// Datasets that tests can load, by reference, each of which is either a dataset or the name
+	// of a fixture file containing one
+	Datasets map[string]interface{}
+	// Starlark modules that tests can load, or not
+	Modules *moduleDetails
+	// Longest amount of time a single case may run for, such as "10s"
+	Timeout string
+	// What to do with code blocks that have no fixture: "missing" (the default) is an error,
+	// "pass" is a trivial success, "ignore" doesn't count the code block at all, "check" parses
+	// the code to make sure its syntax is valid, and "run" runs the code without any assertions
+	Unannotated string
+	// Actions for code blocks that have no fixture, depending upon their language. These take
+	// precedence over Unannotated.
+	UnannotatedRules []unannotatedRule `json:"unannotated_rules"`
+}
+
+// unannotatedRule is an action to take for code blocks in certain languages that have no fixture
+type unannotatedRule struct {
+	Langs  []string
+	Action string
+}
+
+// unannotatedModes are the allowed values for documentDetails.Unannotated
+var unannotatedModes = []string{"missing", "pass", "ignore", "check", "run"}
+
+// checkUnannotated makes sure the unannotated mode, and each rule's action, are allowed values.
+func checkUnannotated(mode string, rules []unannotatedRule) error {
+	if mode != "" && !containsString(unannotatedModes, mode) {
+		return fmt.Errorf("unknown unannotated mode \"%s\", expected one of: %s",
+			mode, strings.Join(unannotatedModes, ", "))
+	}
+	for i, rule := range rules {
+		if !containsString(unannotatedModes, rule.Action) {
+			return fmt.Errorf("unannotated_rules %d: unknown action \"%s\", expected one of: %s",
+				i, rule.Action, strings.Join(unannotatedModes, ", "))
+		}
+		if len(rule.Langs) == 0 {
+			return fmt.Errorf("unannotated_rules %d: field \"langs\" is required", i)
+		}
+	}
+	return nil
+}
+
+// parseDocumentConfig parses the text of a docrun-config comment block.
+func parseDocumentConfig(text string) (*documentDetails, error) {
+	var fields map[string]interface{}
+	err := yaml.Unmarshal([]byte(text), &fields)
+	if err != nil {
+		return nil, err
+	}
+	return fillDocumentConfig(fields)
+}
+
+// fillDocumentConfig fills and validates document configuration from deserialized fields.
+func fillDocumentConfig(fields map[string]interface{}) (*documentDetails, error) {
+	err := checkFields(fields, reflect.TypeOf(DocrunConfig{}))
+	if err != nil {
+		return nil, err
+	}
+	config := DocrunConfig{}
+	err = fill.Struct(fields, &config)
+	if err != nil {
+		return nil, err
+	}
+	details := &config.Config
+	if details.Timeout != "" {
+		if _, err := time.ParseDuration(details.Timeout); err != nil {
+			return nil, fmt.Errorf("invalid timeout \"%s\": %s", details.Timeout, err)
+		}
+	}
+	if details.WebProxy != nil {
+		collector := fill.NewErrorCollector()
+		collector.PushField("webproxy")
+		details.WebProxy.checkRequired(collector)
+		if err := collector.AsSingleError(); err != nil {
+			return nil, err
+		}
+	}
+	err = checkDatasetRefs(details.Datasets)
+	if err != nil {
+		return nil, err
+	}
+	err = details.Modules.checkModules()
+	if err != nil {
+		return nil, err
+	}
+	err = checkUnannotated(details.Unannotated, details.UnannotatedRules)
+	if err != nil {
+		return nil, err
+	}
+	err = checkLangAliases(details.LangAliases)
+	if err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// splitFrontMatter separates YAML front matter, delimited by lines of "---", from the beginning
+// of a markdown document. The returned markdown has blank lines in place of the front matter, so
+// that line numbers are unchanged.
+func splitFrontMatter(md []byte) (frontMatter, body []byte) {
+	if !bytes.HasPrefix(md, []byte("---\n")) {
+		return nil, md
+	}
+	end := bytes.Index(md[4:], []byte("\n---\n"))
+	if end == -1 {
+		return nil, md
+	}
+	end += 4
+	frontMatter = md[4:end]
+	blank := bytes.Repeat([]byte("\n"), bytes.Count(md[:end+5], []byte("\n")))
+	body = append(blank, md[end+5:]...)
+	return frontMatter, body
+}
+
+// parseFrontMatter finds document configuration in the "docrun" field of YAML front matter.
+// Other fields are ignored, since front matter is often shared with other tools.
+func parseFrontMatter(frontMatter []byte) (*documentDetails, error) {
+	var fields map[string]interface{}
+	err := yaml.Unmarshal(frontMatter, &fields)
+	if err != nil {
+		return nil, fmt.Errorf("front matter: %s", err)
+	}
+	config, ok := fields["docrun"]
+	if !ok {
+		return nil, nil
+	}
+	details, err := fillDocumentConfig(map[string]interface{}{"docrun-config": config})
+	if err != nil {
+		return nil, fmt.Errorf("front matter: %s", err)
+	}
+	return details, nil
+}
+
+// SetDocumentConfig assigns the document-level configuration. It is an error to have more than
+// one, or to have one after fixtures have already been seen.
+func (f *DocRunner) SetDocumentConfig(config *documentDetails) error {
+	if f.DocConfig != nil {
+		return fmt.Errorf("document has more than one docrun-config")
+	}
+	if len(f.cases) > 0 || f.FixtureLine != 0 {
+		return fmt.Errorf("docrun-config must come before any docrun fixtures")
+	}
+	f.DocConfig = config
+	return nil
+}
+
+// applyDocumentDefaults fills in fields of a fixture that it doesn't set itself.
+func (f *DocRunner) applyDocumentDefaults(fixture *DocrunFixture) {
+	if f.DocConfig == nil {
+		return
+	}
+	if test := fixture.Docrun.Test; test != nil {
+		if test.Setup == "" {
+			test.Setup = f.DocConfig.Setup
+		}
+		if test.WebProxy == nil {
+			test.WebProxy = f.DocConfig.WebProxy
+		}
+	}
+}
+
+// project returns the project configuration, or the defaults if there isn't one.
+func (f *DocRunner) project() *ProjectConfig {
+	if f.Project == nil {
+		f.Project = DefaultProjectConfig()
+	}
+	return f.Project
+}
+
+// defaultLang returns the language for code blocks that don't specify one. Document
+// configuration takes precedence over project configuration.
+func (f *DocRunner) defaultLang() string {
+	if f.DocConfig != nil && f.DocConfig.Lang != "" {
+		return f.DocConfig.Lang
+	}
+	return f.project().Lang
+}
+
+// unannotatedMode returns what to do with a code block in the given language that has no
+// fixture. Rules for the language are used first, then the default mode, and in both cases the
+// document configuration takes precedence over the project configuration.
+func (f *DocRunner) unannotatedMode(lang string) string {
+	rules := f.project().UnannotatedRules
+	if f.DocConfig != nil {
+		rules = append(append([]unannotatedRule{}, f.DocConfig.UnannotatedRules...), rules...)
+	}
+	// Rules may name the language as written, or the language it resolves to.
+	canonical, _ := f.resolveLang(lang)
+	for _, rule := range rules {
+		if containsString(rule.Langs, lang) || containsString(rule.Langs, canonical) {
+			return rule.Action
+		}
+	}
+	if f.DocConfig != nil && f.DocConfig.Unannotated != "" {
+		return f.DocConfig.Unannotated
+	}
+	if f.project().Unannotated != "" {
+		return f.project().Unannotated
+	}
+	return "missing"
+}
+
+// timeout returns the longest a single case may run for, or the empty string for no limit.
+func (f *DocRunner) timeout() string {
+	if f.DocConfig != nil && f.DocConfig.Timeout != "" {
+		return f.DocConfig.Timeout
+	}
+	return f.project().Timeout
+}
+
+// containsString returns whether the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, elem := range list {
+		if elem == s {
+			return true
+		}
+	}
+	return false
+}
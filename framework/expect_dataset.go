@@ -0,0 +1,130 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+	stards "github.com/qri-io/qri/startf/ds"
+	starutil "github.com/qri-io/starlib/util"
+	"go.starlark.net/starlark"
+)
+
+// datasetComponents are the components of `ds` that expect_dataset can compare
+var datasetComponents = []string{"body", "meta", "structure"}
+
+// checkExpectDataset makes sure each expected component can be compared.
+func checkExpectDataset(expect map[string]interface{}, collector *fill.ErrorCollector) {
+	components := make([]string, 0, len(expect))
+	for component := range expect {
+		components = append(components, component)
+	}
+	sort.Strings(components)
+	for _, component := range components {
+		if !containsString(datasetComponents, component) {
+			collector.Add(fmt.Errorf("unknown component \"%s\", expected one of: %s", component,
+				strings.Join(datasetComponents, ", ")))
+		}
+	}
+}
+
+// compareDataset compares the components of `ds` to those expected, reporting every difference.
+// Only the fields given for meta and structure are compared, at any depth, since the rest are
+// often filled in with defaults, while the body must match exactly.
+func compareDataset(thread *starlark.Thread, ds *stards.Dataset, expect map[string]interface{}) error {
+	methods := ds.Methods()
+	collector := fill.NewErrorCollector()
+	for _, component := range datasetComponents {
+		want, ok := expect[component]
+		if !ok {
+			continue
+		}
+		getter, err := methods.Attr("get_" + component)
+		if err != nil {
+			return err
+		}
+		val, err := starlark.Call(thread, getter, nil, nil)
+		if err != nil {
+			return fmt.Errorf("getting %s: %s", component, err)
+		}
+		actual, err := datasetValue(val)
+		if err != nil {
+			return fmt.Errorf("getting %s: %s", component, err)
+		}
+		collector.PushField(component)
+		compareExpected(actual, jsonCompatible(want), component != "body", collector)
+		collector.PopField()
+	}
+	if err := collector.AsSingleError(); err != nil {
+		return fmt.Errorf("dataset mismatch\n  %s", strings.Replace(err.Error(), "\n", "\n  ", -1))
+	}
+	return nil
+}
+
+// datasetValue converts a component returned by `ds` into the same types as decoded JSON.
+func datasetValue(val starlark.Value) (interface{}, error) {
+	native, err := starutil.Unmarshal(val)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(jsonCompatible(native))
+	if err != nil {
+		return nil, err
+	}
+	var decoded interface{}
+	err = json.Unmarshal(data, &decoded)
+	return decoded, err
+}
+
+// compareExpected compares an actual value to the expected one. If partial, the actual value and
+// the objects nested in it may have fields that aren't expected.
+func compareExpected(actual, expect interface{}, partial bool, collector *fill.ErrorCollector) {
+	switch exp := expect.(type) {
+	case map[string]interface{}:
+		act, ok := actual.(map[string]interface{})
+		if !ok {
+			collector.Add(fmt.Errorf("actual %s, expect %s", showValue(actual), showValue(expect)))
+			return
+		}
+		keys := make([]string, 0, len(exp))
+		for key := range exp {
+			keys = append(keys, key)
+		}
+		if !partial {
+			for key := range act {
+				if _, ok := exp[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collector.PushField(key)
+			if val, ok := act[key]; !ok {
+				collector.Add(fmt.Errorf("missing, expect %s", showValue(exp[key])))
+			} else if want, ok := exp[key]; !ok {
+				collector.Add(fmt.Errorf("unexpected value %s", showValue(val)))
+			} else {
+				compareExpected(val, want, partial, collector)
+			}
+			collector.PopField()
+		}
+	case []interface{}:
+		act, ok := actual.([]interface{})
+		if !ok || len(act) != len(exp) {
+			collector.Add(fmt.Errorf("actual %s, expect %s", showValue(actual), showValue(expect)))
+			return
+		}
+		for i := range exp {
+			collector.PushField(fmt.Sprintf("%d", i))
+			compareExpected(act[i], exp[i], partial, collector)
+			collector.PopField()
+		}
+	default:
+		if !sameScalar(expect, actual) {
+			collector.Add(fmt.Errorf("actual %s, expect %s", showValue(actual), showValue(expect)))
+		}
+	}
+}
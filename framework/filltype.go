@@ -0,0 +1,328 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/qri-io/dataset"
+	"github.com/qri-io/jsonschema"
+	"github.com/qri-io/qri/base/fill"
+	"github.com/qri-io/qri/config"
+	"gopkg.in/yaml.v2"
+)
+
+// schemaPrefix begins filltypes that name a JSON Schema file to validate against, rather than a
+// structure to fill
+const schemaPrefix = "schema:"
+
+// registeredFilltypes make a new value of each filltype's structure, to fill from a code block
+var registeredFilltypes = map[string]func() interface{}{}
+
+// unavailableFilltypes are dataset components that the version of github.com/qri-io/dataset
+// docrun builds with doesn't have, so they can't be filled until it's upgraded
+var unavailableFilltypes = []string{"dataset.Readme", "dataset.Stats"}
+
+func init() {
+	// Dataset components
+	RegisterFilltype("dataset.Dataset", func() interface{} { return &dataset.Dataset{} })
+	RegisterFilltype("dataset.Meta", func() interface{} { return &dataset.Meta{} })
+	RegisterFilltype("dataset.Structure", func() interface{} { return &dataset.Structure{} })
+	RegisterFilltype("dataset.Transform", func() interface{} { return &dataset.Transform{} })
+	RegisterFilltype("dataset.Commit", func() interface{} { return &dataset.Commit{} })
+	RegisterFilltype("dataset.Viz", func() interface{} { return &dataset.Viz{} })
+	// Qri configuration
+	RegisterFilltype("config.Config", func() interface{} { return &config.Config{} })
+	RegisterFilltype("config.API", func() interface{} { return &config.API{} })
+	RegisterFilltype("config.CLI", func() interface{} { return &config.CLI{} })
+	RegisterFilltype("config.Logging", func() interface{} { return &config.Logging{} })
+	RegisterFilltype("config.P2P", func() interface{} { return &config.P2P{} })
+	RegisterFilltype("config.ProfilePod", func() interface{} { return &config.ProfilePod{} })
+	RegisterFilltype("config.Registry", func() interface{} { return &config.Registry{} })
+	RegisterFilltype("config.Render", func() interface{} { return &config.Render{} })
+	RegisterFilltype("config.Repo", func() interface{} { return &config.Repo{} })
+	RegisterFilltype("config.RPC", func() interface{} { return &config.RPC{} })
+	RegisterFilltype("config.Store", func() interface{} { return &config.Store{} })
+	RegisterFilltype("config.Update", func() interface{} { return &config.Update{} })
+	RegisterFilltype("config.Webapp", func() interface{} { return &config.Webapp{} })
+}
+
+// RegisterFilltype adds a filltype, replacing any that was already registered with the name.
+// newValue must return a pointer to a new value of the structure, for the code block to fill.
+func RegisterFilltype(name string, newValue func() interface{}) {
+	registeredFilltypes[name] = newValue
+}
+
+// isFilltype returns whether the name is a known filltype.
+func isFilltype(name string) bool {
+	if name == "json" || strings.HasPrefix(name, schemaPrefix) {
+		return true
+	}
+	_, ok := registeredFilltypes[name]
+	return ok
+}
+
+// filltypeNames returns the sorted names of every known filltype.
+func filltypeNames() []string {
+	names := []string{"json"}
+	for name := range registeredFilltypes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveFilltype returns the known filltype for a name, since the project may define additional
+// names for filltypes.
+func (f *DocRunner) resolveFilltype(filltype string) string {
+	if target, ok := f.project().Filltypes[filltype]; ok {
+		return target
+	}
+	return filltype
+}
+
+// filltypeCase is the CaseContext.Config for filltype cases
+type filltypeCase struct {
+	// Name of the filltype, after resolving the project's names for filltypes
+	Filltype string
+	// Whether to check the meaning of the filled structure
+	Validate bool
+	// Whether to check that the filled structure encodes back to the same data
+	Roundtrip bool
+	// Path of the JSON Schema file for "schema:" filltypes
+	Schema string
+}
+
+// filltypeCase returns the configuration of a filltype case, finding its schema file if needed.
+func (f *DocRunner) filltypeCase(details *docrunDetails) (*filltypeCase, error) {
+	filltype := details.Filltype
+	if details.Schema != "" {
+		filltype = schemaPrefix + details.Schema
+	}
+	config := &filltypeCase{Filltype: f.resolveFilltype(filltype), Validate: details.Validate,
+		Roundtrip: details.Roundtrip}
+	if strings.HasPrefix(config.Filltype, schemaPrefix) {
+		name := strings.TrimPrefix(config.Filltype, schemaPrefix)
+		path, err := f.project().ResolveFixture(f.docDir(), name)
+		if err != nil {
+			return nil, err
+		}
+		config.Schema = path
+	}
+	return config, nil
+}
+
+// filltypePlugin parses code blocks using a filltype, to make sure they are valid. It handles
+// every language, and expects CaseContext.Config to be *filltypeCase.
+type filltypePlugin struct {
+	// Schemas that have been loaded, by path
+	schemas map[string]*jsonschema.RootSchema
+}
+
+// Prepare does nothing, schemas are loaded as they are needed
+func (p *filltypePlugin) Prepare(c *CaseContext) error {
+	return nil
+}
+
+// Run decodes the code block according to its language, then fills the filltype's structure
+func (p *filltypePlugin) Run(c *CaseContext) error {
+	details := c.Config.(*filltypeCase)
+	if details.Schema != "" {
+		return p.validateSchema(details.Schema, c.Lang, c.Source)
+	}
+	filltype := details.Filltype
+	newValue, ok := registeredFilltypes[filltype]
+	if filltype != "json" && !ok {
+		if containsString(unavailableFilltypes, filltype) {
+			return fmt.Errorf("filltype %s needs a newer version of github.com/qri-io/dataset",
+				filltype)
+		}
+		return fmt.Errorf("unknown filltype %s", filltype)
+	}
+	data, err := decodeData(c.Lang, c.Source)
+	if err != nil {
+		return err
+	}
+	if filltype == "json" {
+		// Any data is fine, as long as it decodes.
+		if details.Validate {
+			return fmt.Errorf("filltype json has no validation")
+		}
+		return nil
+	}
+	value := newValue()
+	err = fillValue(data, value)
+	if err != nil {
+		return err
+	}
+	if details.Validate {
+		err = validateFilled(filltype, value)
+		if err != nil {
+			return err
+		}
+	}
+	if details.Roundtrip {
+		return checkRoundtrip(data, value)
+	}
+	return nil
+}
+
+// validateSchema decodes the code block and validates it against a JSON Schema file, reporting
+// the JSON pointer of each invalid value.
+func (p *filltypePlugin) validateSchema(path, lang, source string) error {
+	schema, err := p.loadSchema(path)
+	if err != nil {
+		return err
+	}
+	data, err := decodeData(lang, source)
+	if err != nil {
+		return err
+	}
+	bytes, err := json.Marshal(jsonCompatible(data))
+	if err != nil {
+		return err
+	}
+	errs, err := validateSchemaBytes(schema, bytes)
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	msgs := make([]string, len(errs))
+	for i, valErr := range errs {
+		pointer := valErr.PropertyPath
+		if pointer == "" {
+			pointer = "/"
+		}
+		msgs[i] = fmt.Sprintf("at %s: %s", pointer, schemaErrorMessage(valErr))
+	}
+	return fmt.Errorf("%s", strings.Join(msgs, "\n"))
+}
+
+// loadSchema reads a JSON Schema file, in either JSON or YAML, the first time it is used.
+func (p *filltypePlugin) loadSchema(path string) (*jsonschema.RootSchema, error) {
+	if schema, ok := p.schemas[path]; ok {
+		return schema, nil
+	}
+	text, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var data interface{}
+	// YAML is a superset of JSON, so it can decode either.
+	err = yaml.Unmarshal(text, &data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	schema, err := parseSchema(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	if p.schemas == nil {
+		p.schemas = map[string]*jsonschema.RootSchema{}
+	}
+	p.schemas[path] = schema
+	return schema, nil
+}
+
+// Cleanup does nothing, loaded schemas are kept for later cases
+func (p *filltypePlugin) Cleanup(c *CaseContext) error {
+	return nil
+}
+
+// decodeData decodes structured data in a language that filltypes support.
+func decodeData(lang, source string) (interface{}, error) {
+	var data interface{}
+	switch lang {
+	case "json":
+		err := json.Unmarshal([]byte(source), &data)
+		return data, err
+	case "yaml":
+		err := yaml.Unmarshal([]byte(source), &data)
+		return data, err
+	case "toml":
+		var table map[string]interface{}
+		_, err := toml.Decode(source, &table)
+		return normalizeTOML(table), err
+	default:
+		return nil, fmt.Errorf("filltype can't decode language %s, expected one of: json, toml, yaml",
+			lang)
+	}
+}
+
+// normalizeTOML converts values decoded from TOML into the types fill.Struct expects, which are
+// the same ones JSON decodes to. Integers become ints, datetimes become RFC3339 strings, and
+// every list becomes []interface{}.
+func normalizeTOML(val interface{}) interface{} {
+	switch v := val.(type) {
+	case map[string]interface{}:
+		for key, elem := range v {
+			v[key] = normalizeTOML(elem)
+		}
+		return v
+	case []map[string]interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = normalizeTOML(elem)
+		}
+		return list
+	case []interface{}:
+		for i, elem := range v {
+			v[i] = normalizeTOML(elem)
+		}
+		return v
+	case int64:
+		return int(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return val
+	}
+}
+
+// fillValue assigns decoded data to a structure. Data that isn't an object, such as a list or a
+// scalar, can fill anything that isn't a struct, such as a slice of structs.
+func fillValue(data interface{}, output interface{}) error {
+	target := reflect.TypeOf(output).Elem()
+	if fields := toStringMap(data); fields != nil && target.Kind() == reflect.Struct {
+		return fill.Struct(fields, output)
+	}
+	if target.Kind() == reflect.Struct {
+		return fmt.Errorf("expected an object for %s, got %s", target, describeData(data))
+	}
+	// fill.Struct only fills structs, so wrap the value in one.
+	wrapper := reflect.New(reflect.StructOf([]reflect.StructField{{Name: "Value", Type: target}}))
+	err := fill.Struct(map[string]interface{}{"value": data}, wrapper.Interface())
+	if err != nil {
+		// Errors shouldn't mention the wrapper.
+		msg := strings.Replace(err.Error(), "at Value.", "at ", -1)
+		msg = strings.Replace(msg, "at Value: ", "", -1)
+		return fmt.Errorf("%s", msg)
+	}
+	reflect.ValueOf(output).Elem().Set(wrapper.Elem().Field(0))
+	return nil
+}
+
+// describeData returns the kind of a decoded value, for error messages.
+func describeData(data interface{}) string {
+	switch data.(type) {
+	case nil:
+		return "nothing"
+	case []interface{}:
+		return "a list"
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case map[string]interface{}, map[interface{}]interface{}:
+		return "an object"
+	default:
+		return "a number"
+	}
+}
@@ -0,0 +1,124 @@
+package framework
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/gomarkdown/markdown/ast"
+)
+
+// CaseFilter selects a subset of cases to run, by their ID and tags
+type CaseFilter struct {
+	// Cases must have an ID matching this expression, if set
+	Run *regexp.Regexp
+	// Cases must have at least one of these tags, if any are set
+	Tags []string
+}
+
+// NewCaseFilter returns a filter from the text of command-line flags, an expression to match
+// case IDs and a comma-separated list of tags. Either may be empty.
+func NewCaseFilter(run, tags string) (*CaseFilter, error) {
+	filter := &CaseFilter{}
+	if run != "" {
+		re, err := regexp.Compile(run)
+		if err != nil {
+			return nil, fmt.Errorf("invalid run expression: %s", err)
+		}
+		filter.Run = re
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			filter.Tags = append(filter.Tags, tag)
+		}
+	}
+	return filter, nil
+}
+
+// Matches returns whether a case with the ID and tags should be run.
+func (c *CaseFilter) Matches(id string, tags []string) bool {
+	if c == nil {
+		return true
+	}
+	if c.Run != nil && !c.Run.MatchString(id) {
+		return false
+	}
+	if len(c.Tags) == 0 {
+		return true
+	}
+	for _, tag := range tags {
+		if containsString(c.Tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// startSection begins a new section of the document at a heading. Unnamed cases get an ID from
+// the heading of their section and their position within it, so that adding a code block only
+// changes the IDs of blocks after it in the same section.
+func (f *DocRunner) startSection(heading *ast.Heading) {
+	slug := slugify(headingText(heading))
+	if slug == "" {
+		slug = "section"
+	}
+	// Headings with the same text get a numeric suffix, so IDs stay unique.
+	if f.slugs == nil {
+		f.slugs = map[string]int{}
+	}
+	f.slugs[slug]++
+	if n := f.slugs[slug]; n > 1 {
+		slug = fmt.Sprintf("%s-%d", slug, n-1)
+	}
+	f.section = slug
+	f.sectionIndex = 0
+	f.unannotatedIndex = 0
+}
+
+// nextCaseID returns the ID of a case, using its name if it has one. Code blocks without a
+// fixture are numbered separately, so that adding one doesn't change the IDs of the examples.
+func (f *DocRunner) nextCaseID(annotated bool, fixture *DocrunFixture) string {
+	section := f.section
+	if section == "" {
+		section = "top"
+	}
+	if !annotated {
+		f.unannotatedIndex++
+		return fmt.Sprintf("%s/unannotated-%d", section, f.unannotatedIndex)
+	}
+	f.sectionIndex++
+	if fixture != nil && fixture.Docrun.Name != "" {
+		return fixture.Docrun.Name
+	}
+	return fmt.Sprintf("%s/%d", section, f.sectionIndex)
+}
+
+// headingText returns the plain text of a heading.
+func headingText(node ast.Node) string {
+	if leaf := node.AsLeaf(); leaf != nil {
+		return string(leaf.Literal)
+	}
+	text := ""
+	for _, child := range node.GetChildren() {
+		text += headingText(child)
+	}
+	return text
+}
+
+// slugify converts text to lowercase words separated by dashes.
+func slugify(text string) string {
+	slug := strings.Builder{}
+	dash := false
+	for _, r := range strings.ToLower(text) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && slug.Len() > 0 {
+				slug.WriteRune('-')
+			}
+			slug.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return slug.String()
+}
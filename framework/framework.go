@@ -4,12 +4,17 @@
 package framework
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
 	"strings"
 
+	"github.com/gomarkdown/markdown"
 	"github.com/gomarkdown/markdown/ast"
-	"github.com/qri-io/dataset"
+	"github.com/gomarkdown/markdown/html"
 	"github.com/qri-io/qri/base/fill"
 	"gopkg.in/yaml.v2"
 )
@@ -17,12 +22,48 @@ import (
 // DocRunner maintains state to process nodes, run examples, and collect results
 type DocRunner struct {
 	Errs        []error
+	Warnings    []error
+	Project     *ProjectConfig
+	Filter      *CaseFilter
+	Record      bool
+	Path        string
+	Document    []byte
+	DocConfig   *documentDetails
 	Fixture     *DocrunFixture
+	FixtureLine int
+	FixtureErr  error
 	Source      *DocrunSource
-	CaseError   bool
 	Results     RunResults
-	Starlark    *StarlarkRunner
-	CommandLine *CommandLineRunner
+	// Position in Document of the most recently located node
+	cursor int
+	// Cases collected while parsing, waiting to be run
+	cases []docrunCase
+	// Whether any fixture in the document is marked "only"
+	hasOnly bool
+	// ID of the case being run
+	caseID string
+	// Runners made for this document, see RegisterRunner
+	runners map[runnerKey]Runner
+	// Sessions used by each of the runners, which end if the runner is discarded
+	runnerSessions map[runnerKey][]string
+	// Sessions that ended since a case of their runner timed out, and the ID of that case
+	timedOut map[string]string
+	// Slug of the current section's heading, the number of cases and of code blocks without a
+	// fixture in it, and how many times each heading slug has been used
+	section          string
+	sectionIndex     int
+	unannotatedIndex int
+	slugs            map[string]int
+}
+
+// docrunCase is a code block along with the fixture that precedes it. Cases are collected while
+// parsing, and run once the whole document is known.
+type docrunCase struct {
+	ID      string
+	Fixture *DocrunFixture
+	Source  *DocrunSource
+	// Error from a fixture that couldn't be parsed, or that has no code block (and no Source)
+	Err error
 }
 
 // RunResults collects results from a run of docrun
@@ -31,6 +72,19 @@ type RunResults struct {
 	CountSuccess int
 	CountTrivial int
 	CountMissing int
+	// Fixtures that never got matched up with a code block
+	CountOrphaned int
+	// Code blocks without fixtures that were handled by an unannotated rule, and those ignored
+	// by one, which aren't included in CountTotal
+	CountUnannotated int
+	CountIgnored     int
+	// Cases that were skipped, either by being marked skip, or because other cases were marked
+	// only, and cases that failed as expected
+	CountSkipped    int
+	CountDeselected int
+	CountXFail      int
+	// Cases that were parsed to check their syntax, without being run
+	CountChecked int
 }
 
 // AddSuccess counts up a successfully ran case
@@ -46,20 +100,143 @@ func (r *RunResults) AddMissing() {
 	r.CountMissing++
 }
 
+// AddOrphaned counts that a fixture doesn't have a code block
+func (r *RunResults) AddOrphaned() {
+	r.CountOrphaned++
+}
+
+// AddSkipped counts that a case was marked skip
+func (r *RunResults) AddSkipped() {
+	r.CountSkipped++
+}
+
+// AddDeselected counts that a case wasn't run because other cases are marked only, or because it
+// didn't match the filter
+func (r *RunResults) AddDeselected() {
+	r.CountDeselected++
+}
+
+// AddXFail counts that a case marked xfail failed, as expected
+func (r *RunResults) AddXFail() {
+	r.CountXFail++
+}
+
+// AddChecked counts that a case's syntax is valid
+func (r *RunResults) AddChecked() {
+	r.CountChecked++
+}
+
+// Failures returns the number of cases that failed, including those missing a fixture
+func (r *RunResults) Failures() int {
+	return r.CountTotal - r.CountSuccess - r.CountSkipped - r.CountDeselected - r.CountXFail -
+		r.CountChecked
+}
+
 // Empty returns whether there were no tests run at all
 func (r *RunResults) Empty() bool {
-	return r.CountTotal == 0
+	return r.CountTotal == 0 && r.CountOrphaned == 0 && r.CountIgnored == 0
 }
 
-// Init assigns initial state to the DocRunner
+// Init assigns initial state to the DocRunner. The project configuration and filter are kept,
+// since they are shared by every document.
 func (f *DocRunner) Init() {
 	f.Errs = []error{}
+	f.Warnings = []error{}
+	f.Path = ""
+	f.Document = nil
+	f.DocConfig = nil
+	f.cursor = 0
 	f.Fixture = nil
+	f.FixtureLine = 0
+	f.FixtureErr = nil
 	f.Source = nil
-	f.CaseError = false
+	f.cases = nil
+	f.hasOnly = false
+	f.caseID = ""
+	f.section = ""
+	f.sectionIndex = 0
+	f.unannotatedIndex = 0
+	f.slugs = nil
 	f.Results = RunResults{}
-	f.Starlark = NewStarlarkRunner()
-	f.CommandLine = NewCommandLineRunner()
+	f.runners = nil
+	f.runnerSessions = nil
+	f.timedOut = nil
+}
+
+// RunFile reads a markdown file and runs it. Init should be called first.
+func (f *DocRunner) RunFile(path string) error {
+	md, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	f.Path = path
+	f.RunDocument(md)
+	return nil
+}
+
+// docDir returns the directory of the document being run, for finding files it refers to.
+func (f *DocRunner) docDir() string {
+	if f.Path == "" {
+		return "."
+	}
+	return filepath.Dir(f.Path)
+}
+
+// RunDocument parses a markdown document, then runs each test case that was found. Init should
+// be called first.
+func (f *DocRunner) RunDocument(md []byte) {
+	frontMatter, md := splitFrontMatter(md)
+	if frontMatter != nil {
+		config, err := parseFrontMatter(frontMatter)
+		if err != nil {
+			f.Errs = append(f.Errs, err)
+		} else if config != nil {
+			f.DocConfig = config
+		}
+	}
+	f.Document = md
+	// This library converts markdown to html, with a hook for parsed nodes. We ignore the html
+	// output, and only care about the ast nodes while parsing is happening.
+	opts := html.RendererOptions{
+		Flags: html.CommonFlags,
+		RenderNodeHook: func(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
+			// Only process nodes when they open up.
+			if entering {
+				f.AddNode(node)
+			}
+			// Tell the parser to continue walking the ast normally.
+			return ast.GoToNext, false
+		},
+	}
+	_ = markdown.ToHTML(md, nil, html.NewRenderer(opts))
+	f.Finish()
+}
+
+// Finish is called once the whole document has been parsed. Any fixture still waiting for its
+// code block is an error. Then each case is run, in document order.
+func (f *DocRunner) Finish() {
+	f.orphanPending("is at the end of the document, without a code block following it")
+	// If any fixture is marked "only", then only those cases are run.
+	f.hasOnly = false
+	for _, c := range f.cases {
+		if c.Fixture != nil && c.Fixture.Docrun.Only {
+			f.hasOnly = true
+		}
+	}
+	for _, c := range f.cases {
+		if c.Source == nil {
+			f.AddOrphanError(c.Err)
+			continue
+		}
+		f.caseID = c.ID
+		f.Fixture = c.Fixture
+		f.Source = c.Source
+		f.FixtureErr = c.Err
+		f.RunFixture()
+		f.ClearState()
+	}
+	f.cases = nil
+	f.caseID = ""
 }
 
 // HandleNode is given each parsed ast node, and collects information about tests to run
@@ -73,6 +250,18 @@ func (f *DocRunner) HandleNode(node ast.Node) (*DocrunFixture, *DocrunSource, er
 				text = text[4 : len(text)-3]
 				text = strings.TrimSpace(text)
 
+				// A comment block that starts with "docrun-config" has document-level defaults.
+				if strings.HasPrefix(text, "docrun-config") {
+					config, err := parseDocumentConfig(text)
+					if err == nil {
+						err = f.SetDocumentConfig(config)
+					}
+					if err != nil {
+						f.Errs = append(f.Errs, fmt.Errorf("docrun-config: %s", err))
+					}
+					return nil, nil, nil
+				}
+
 				// Only run over comment blocks that start with the string "docrun".
 				if !strings.HasPrefix(text, "docrun") {
 					return nil, nil, nil
@@ -84,11 +273,34 @@ func (f *DocRunner) HandleNode(node ast.Node) (*DocrunFixture, *DocrunSource, er
 					return nil, nil, err
 				}
 
+				raw := map[string]interface{}{}
+				for key, val := range toStringMap(fields["docrun"]) {
+					raw[key] = val
+				}
+				// Modes registered from outside the framework aren't fields of the fixture.
+				custom := takeCustomModes(fields)
+
+				// Check for unknown fields first, since those errors are more helpful than the
+				// ones from fill.Struct.
+				err = checkFields(fields, reflect.TypeOf(DocrunFixture{}))
+				if err != nil {
+					return nil, nil, err
+				}
+
 				fixture := DocrunFixture{}
 				err = fill.Struct(fields, &fixture)
 				if err != nil {
 					return nil, nil, err
 				}
+				fixture.Docrun.custom = custom
+				fixture.Docrun.raw = raw
+				if fixture.Docrun.Test != nil {
+					fixture.Docrun.Test.markExpects(raw["test"])
+				}
+				err = fixture.Docrun.checkModes()
+				if err != nil {
+					return nil, nil, err
+				}
 				return &fixture, nil, nil
 			}
 		}
@@ -98,7 +310,7 @@ func (f *DocRunner) HandleNode(node ast.Node) (*DocrunFixture, *DocrunSource, er
 	if cb, ok := node.(*ast.CodeBlock); ok {
 		leaf := node.AsLeaf()
 		if leaf != nil {
-			return nil, &DocrunSource{string(leaf.Literal), string(cb.Info)}, nil
+			return nil, &DocrunSource{Code: string(leaf.Literal), Lang: string(cb.Info)}, nil
 		}
 	}
 
@@ -107,56 +319,168 @@ func (f *DocRunner) HandleNode(node ast.Node) (*DocrunFixture, *DocrunSource, er
 
 // AddNode collects a node, either a fixture represented by a HTML comment block, or source code.
 func (f *DocRunner) AddNode(node ast.Node) {
+	line := f.nodeLine(node)
 	fixture, source, err := f.HandleNode(node)
-	if err != nil {
-		f.AddError(err)
-		f.CaseError = true
-		return
-	}
-	if f.Fixture == nil && fixture != nil {
+	if err != nil || fixture != nil {
+		// Only one fixture may precede a code block.
+		f.orphanPending(fmt.Sprintf("is followed by another fixture at line %d, without a "+
+			"code block in between", line))
 		// Hold onto fixture until the source code is also parsed.
-		f.Fixture = fixture
+		if err != nil {
+			f.FixtureErr = fmt.Errorf("docrun fixture at line %d: %s", line, err)
+		} else {
+			f.applyDocumentDefaults(fixture)
+			f.Fixture = fixture
+		}
+		f.FixtureLine = line
 		return
 	}
-	if f.Source == nil && source != nil {
-		// Once fixture and source are available, run the test case.
-		f.Source = source
-		f.RunFixture()
+	if source != nil {
+		// Once fixture and source are available, the case is ready to run.
+		source.Line = line
+		annotated := f.Fixture != nil || f.FixtureErr != nil
+		c := docrunCase{ID: f.nextCaseID(annotated, f.Fixture), Fixture: f.Fixture, Source: source, Err: f.FixtureErr}
+		for _, other := range f.cases {
+			if other.ID == c.ID && c.Err == nil {
+				c.Err = fmt.Errorf("case name \"%s\" is used more than once", c.ID)
+			}
+		}
+		f.cases = append(f.cases, c)
 		f.ClearState()
+		return
+	}
+	if heading, ok := node.(*ast.Heading); ok {
+		f.startSection(heading)
 	}
+	// A fixture needs to be immediately followed by its code block, otherwise it's too easy to
+	// lose track of which block it applies to.
+	switch node.(type) {
+	case *ast.Heading:
+		f.orphanPending("is separated from its code block by a heading")
+	case *ast.Paragraph, *ast.BlockQuote, *ast.Table:
+		f.orphanPending("is separated from its code block by prose")
+	case *ast.HorizontalRule:
+		f.orphanPending("is separated from its code block by a horizontal rule")
+	case *ast.HTMLBlock:
+		f.orphanPending("is separated from its code block by HTML")
+	}
+}
+
+// orphanPending handles a fixture that is waiting for its code block, which it will never get.
+// If the fixture couldn't be parsed, that error is reported instead.
+func (f *DocRunner) orphanPending(reason string) {
+	if f.FixtureLine == 0 {
+		return
+	}
+	err := f.FixtureErr
+	if err == nil {
+		err = fmt.Errorf("docrun fixture at line %d %s", f.FixtureLine, reason)
+	}
+	f.cases = append(f.cases, docrunCase{Err: err})
+	f.ClearState()
+}
+
+// nodeLine returns the line number where a leaf node (such as a code block or html comment)
+// begins in the document, or 0 if it can't be found.
+func (f *DocRunner) nodeLine(node ast.Node) int {
+	var text []byte
+	switch n := node.(type) {
+	case *ast.HTMLBlock:
+		text = bytes.TrimSpace(n.Literal)
+	case *ast.CodeBlock:
+		text = bytes.TrimRight(n.Literal, "\n")
+	default:
+		return 0
+	}
+	if len(text) == 0 {
+		return 0
+	}
+	// Nodes are visited in document order, so search from the most recently found node.
+	pos := bytes.Index(f.Document[f.cursor:], text)
+	if pos == -1 {
+		return 0
+	}
+	pos += f.cursor
+	f.cursor = pos + len(text)
+	line := bytes.Count(f.Document[:pos], []byte("\n")) + 1
+	// Point at the opening fence, instead of the first line of code.
+	if cb, ok := node.(*ast.CodeBlock); ok && cb.IsFenced {
+		line--
+	}
+	return line
 }
 
 // ClearState finishes a fixture run by clearing the related state.
 func (f *DocRunner) ClearState() {
 	f.Fixture = nil
+	f.FixtureLine = 0
+	f.FixtureErr = nil
 	f.Source = nil
-	f.CaseError = false
 }
 
 // RunFixture runs a fixture by combining metadata and the source code.
 func (f *DocRunner) RunFixture() {
 	f.Results.CountTotal++
+	if f.FixtureErr != nil {
+		// The fixture couldn't be parsed, so the case can't be run.
+		f.AddError(f.FixtureErr)
+		return
+	}
 	if f.Fixture == nil {
-		// If this fixture case already encountered an an error, don't throw another.
-		if f.CaseError {
+		if !f.Filter.Matches(f.caseID, nil) {
+			f.Results.AddDeselected()
 			return
 		}
-		// Source code blocks should all be immediately preceded by a fixture node. It is an error
-		// to have source code without a fixture node. An easy to silence this is to add:
-		// <!--
-		// docrun:
-		//   pass: true
-		// -->
-		f.AddError(fmt.Errorf("source code block %d is not preceded by a docrun fixture",
-			f.Results.CountTotal))
-		f.Results.AddMissing()
+		f.RunUnannotated()
 		return
 	}
-	if f.Fixture.Docrun.Pass {
-		// A trivially passing test.
-		f.Results.AddSuccess(f.Results.CountTotal, false)
+	details := &f.Fixture.Docrun
+	if details.Skip != "" {
+		log.Infof("case %s skipped: %s", f.caseID, details.Skip)
+		f.Results.AddSkipped()
+		return
+	}
+	if f.hasOnly && !details.Only {
+		log.Infof("case %s skipped: not marked only", f.caseID)
+		f.Results.AddDeselected()
 		return
 	}
+	if !f.Filter.Matches(f.caseID, details.Tags) {
+		log.Infof("case %s skipped: not selected by filter", f.caseID)
+		f.Results.AddDeselected()
+		return
+	}
+	nonTrivial, err := f.runCase()
+	// A case that is expected to fail is tracked separately, and is an error if it passes.
+	if details.Xfail != "" {
+		if err != nil {
+			log.Infof("case %s failed as expected (%s): %s", f.caseID, details.Xfail, err)
+			f.Results.AddXFail()
+			return
+		}
+		f.AddError(fmt.Errorf("source code block %d passed, but is marked xfail: %s",
+			f.Results.CountTotal, details.Xfail))
+		return
+	}
+	if err != nil {
+		f.AddError(err)
+		return
+	}
+	if details.Check != "" {
+		f.Results.AddChecked()
+		return
+	}
+	f.Results.AddSuccess(f.Results.CountTotal, nonTrivial)
+}
+
+// runCase runs the source code of a case according to its fixture, returning whether the case
+// was non-trivial.
+func (f *DocRunner) runCase() (bool, error) {
+	details := &f.Fixture.Docrun
+	if details.Pass {
+		// A trivially passing test.
+		return false, nil
+	}
 
 	lang := ""
 	// If source code has a language tag, use that for the source language.
@@ -164,38 +488,138 @@ func (f *DocRunner) RunFixture() {
 		lang = f.Source.Lang
 	}
 	// Otherwise, if top-level of fixture has a language field, use that.
-	// TODO(dlong): Should it be an error to have both set?
-	if lang == "" && f.Fixture.Docrun.Lang != "" {
-		lang = f.Fixture.Docrun.Lang
+	if lang == "" && details.Lang != "" {
+		lang = details.Lang
+	}
+	// Having both set is fine, as long as they agree, possibly by being aliases.
+	if details.Lang != "" && !f.sameLang(details.Lang, lang) {
+		return false, fmt.Errorf("source code block %d has language \"%s\" but fixture has lang \"%s\"",
+			f.Results.CountTotal, lang, details.Lang)
 	}
+	// Structured data without a language has always been read as YAML.
+	if lang == "" && (details.Filltype != "" || details.Schema != "") {
+		lang = "yaml"
+	}
+	// Lastly, fall back to the default language of the document or project.
 	if lang == "" {
-		f.AddError(fmt.Errorf("source code block %d has no language", f.Results.CountTotal))
-		return
+		lang = f.defaultLang()
+	}
+	if lang == "" {
+		return false, fmt.Errorf("source code block %d has no language", f.Results.CountTotal)
 	}
+	lang = f.caseLang(lang)
 
-	// If there's a filltype, parse the text using that type to make sure it is valid.
-	filltype := f.Fixture.Docrun.Filltype
-	if filltype != "" {
-		f.DispatchFilltype(filltype, f.Source.Code)
-		f.HandleSave(f.Fixture.Docrun.Save, f.Source.Code)
+	c := &CaseContext{ID: f.caseID, Path: f.Path, Lang: lang, Source: f.Source.Code,
+		Session: details.Session}
+	if details.Filltype != "" || details.Schema != "" {
+		// If there's a filltype, parse the text using that type to make sure it is valid.
+		config, err := f.filltypeCase(details)
+		if err != nil {
+			return false, err
+		}
+		c.Mode, c.Config = "filltype", config
+	} else if details.Test != nil {
+		// If there's a test substructure, dispatch it.
+		if err := f.prepareWebProxy(details.Test.WebProxy); err != nil {
+			return false, err
+		}
+		c.Mode, c.Config = "test", details.Test
+	} else if details.Command != nil {
+		// If there's a command, dispatch it.
+		c.Mode, c.Config = "command", details.Command
+	} else if details.Check != "" {
+		// If there's a check, parse the code without running it.
+		check := &checkCase{Kind: details.Check}
+		if details.Compile != nil {
+			check.Imports = details.Compile.Imports
+		}
+		c.Mode, c.Config = "check", check
+	} else if details.Compile != nil {
+		// If there's compile metadata, build the program and possibly run it.
+		c.Mode, c.Config = "compile", details.Compile
+	} else if len(details.custom) != 0 {
+		// Modes registered from outside the framework get their field's value as is.
+		for mode, config := range details.custom {
+			c.Mode, c.Config = mode, config
+		}
+	} else if details.Session != "" {
+		// Code in a session is run, so that later code blocks can use its definitions.
+		c.Mode, c.Config = "test", &testDetails{}
+	}
+	if test, ok := c.Config.(*testDetails); ok {
+		if err := f.prepareTest(test); err != nil {
+			return false, err
+		}
+	}
+	if c.Mode == "filltype" && details.Schema != "" {
+		c.Raw = details.raw["schema"]
+	} else {
+		c.Raw = details.raw[c.Mode]
+	}
+	var err error
+	nonTrivial := c.Mode != ""
+	if nonTrivial {
+		err = f.DispatchCase(c)
+	}
+	f.HandleSave(details.Save, f.Source.Code)
+	return nonTrivial, err
+}
+
+// RunUnannotated handles a code block that has no fixture, depending upon its language.
+func (f *DocRunner) RunUnannotated() {
+	lang := f.Source.Lang
+	if lang == "" {
+		lang = f.defaultLang()
+	}
+	mode := f.unannotatedMode(lang)
+	if mode == "missing" {
+		// Source code blocks should all be immediately preceded by a fixture node. It is an error
+		// to have source code without a fixture node. An easy to silence this is to add:
+		// <!--
+		// docrun:
+		//   pass: true
+		// -->
+		f.AddError(fmt.Errorf("source code block %d is not preceded by a docrun fixture",
+			f.Results.CountTotal))
+		f.Results.AddMissing()
+		return
+	}
+	if mode == "ignore" {
+		f.Results.CountTotal--
+		f.Results.CountIgnored++
+		return
+	}
+	f.Results.CountUnannotated++
+	var err error
+	switch mode {
+	case "pass":
+		f.Results.AddSuccess(f.Results.CountTotal, false)
 		return
+	case "check":
+		err = checkSyntax(f.caseLang(lang), f.Source.Code, f.docDir(), nil)
+	case "run":
+		// Run the code with no assertions, only checking that it doesn't fail.
+		canonical := f.caseLang(lang)
+		if _, _, ok := lookupRunner("test", canonical); !ok {
+			err = fmt.Errorf("cannot run unannotated code in language %s", lang)
+			break
+		}
+		test := &testDetails{}
+		if err = f.prepareTest(test); err != nil {
+			break
+		}
+		err = f.DispatchCase(&CaseContext{ID: f.caseID, Path: f.Path, Mode: "test",
+			Lang: canonical, Source: f.Source.Code, Config: test})
 	}
-	// If there's a test substructure, dispatch it.
-	test := f.Fixture.Docrun.Test
-	if test != nil {
-		f.DispatchTestCase(test, lang, f.Source.Code)
-		f.HandleSave(f.Fixture.Docrun.Save, f.Source.Code)
+	if err != nil {
+		f.AddError(fmt.Errorf("unannotated code block %d: %s", f.Results.CountTotal, err))
 		return
 	}
-	// If there's a command, dispatch it.
-	cmd := f.Fixture.Docrun.Command
-	if cmd != nil {
-		f.DispatchCommandCase(cmd, lang, f.Source.Code)
-		f.HandleSave(f.Fixture.Docrun.Save, f.Source.Code)
+	if mode == "check" {
+		f.Results.AddChecked()
 		return
 	}
-	f.HandleSave(f.Fixture.Docrun.Save, f.Source.Code)
-	f.Results.AddSuccess(f.Results.CountTotal, false)
+	f.Results.AddSuccess(f.Results.CountTotal, true)
 }
 
 // HandleSave saves the source code to a file, for future tests and commands.
@@ -215,6 +639,12 @@ func (f *DocRunner) HasError() bool {
 	return len(f.Errs) > 0
 }
 
+// AddOrphanError adds an error about a fixture that isn't followed by a code block
+func (f *DocRunner) AddOrphanError(err error) {
+	f.Errs = append(f.Errs, err)
+	f.Results.AddOrphaned()
+}
+
 // ShowErrors displays errors to stdout
 func (f *DocRunner) ShowErrors() {
 	for _, err := range f.Errs {
@@ -222,85 +652,69 @@ func (f *DocRunner) ShowErrors() {
 	}
 }
 
+// ShowWarnings displays warnings to stdout
+func (f *DocRunner) ShowWarnings() {
+	for _, err := range f.Warnings {
+		fmt.Printf("Warning: %s\n\n", err)
+	}
+}
+
+// AddWarning adds a warning about the current case, which doesn't cause it to fail
+func (f *DocRunner) AddWarning(err error) {
+	f.Warnings = append(f.Warnings, fmt.Errorf("case %s: %s", f.caseID, err))
+}
+
 // AddError adds an error
 func (f *DocRunner) AddError(err error) {
 	// TODO(dlong): Clean up how this interacts with ShowErrors, which prefixes "Error: "
-	f.Errs = append(f.Errs, fmt.Errorf("case %d: %s", f.Results.CountTotal, err))
+	f.Errs = append(f.Errs, fmt.Errorf("case %s: %s", f.caseID, err))
 }
 
 // DisplayResults displays results from running the test cases.
 func (f *DocRunner) DisplayResults() {
-	if f.Results.CountTrivial == 0 {
+	passDetails := []string{}
+	if f.Results.CountTrivial != 0 {
+		passDetails = append(passDetails, fmt.Sprintf("%d trivial", f.Results.CountTrivial))
+	}
+	if f.Results.CountUnannotated != 0 {
+		passDetails = append(passDetails, fmt.Sprintf("%d unannotated", f.Results.CountUnannotated))
+	}
+	if len(passDetails) == 0 {
 		fmt.Printf("PASS: %d tests\n", f.Results.CountSuccess)
 	} else {
-		fmt.Printf("PASS: %d tests (%d trivial)\n", f.Results.CountSuccess, f.Results.CountTrivial)
+		fmt.Printf("PASS: %d tests (%s)\n", f.Results.CountSuccess, strings.Join(passDetails, ", "))
+	}
+	failNum := f.Results.Failures() + f.Results.CountOrphaned
+	details := []string{}
+	if f.Results.CountMissing != 0 {
+		details = append(details, fmt.Sprintf("%d missing", f.Results.CountMissing))
+	}
+	if f.Results.CountOrphaned != 0 {
+		details = append(details, fmt.Sprintf("%d orphaned", f.Results.CountOrphaned))
 	}
-	failNum := f.Results.CountTotal - f.Results.CountSuccess
-	if f.Results.CountMissing == 0 {
+	if len(details) == 0 {
 		fmt.Printf("FAIL: %d\n", failNum)
 	} else {
-		fmt.Printf("FAIL: %d (%d missing)\n", failNum, f.Results.CountMissing)
+		fmt.Printf("FAIL: %d (%s)\n", failNum, strings.Join(details, ", "))
 	}
-}
-
-// GetResults returns the results from a run of docrun
-func (f *DocRunner) GetResults() RunResults {
-	return f.Results
-}
-
-// DispatchFilltype dispatches a filltype operation.
-func (f *DocRunner) DispatchFilltype(filltype, source string) {
-	var err error
-	var fields map[string]interface{}
-	switch filltype {
-	case "json":
-		err = json.Unmarshal([]byte(source), &fields)
-	case "dataset.Dataset":
-		// TODO(dlong): Support datasets in json format
-		err = yaml.Unmarshal([]byte(source), &fields)
-		if err == nil {
-			ds := dataset.Dataset{}
-			err = fill.Struct(fields, &ds)
-		}
-	default:
-		err = fmt.Errorf("unknown filltype %s", filltype)
+	skipNum := f.Results.CountSkipped + f.Results.CountDeselected
+	if f.Results.CountDeselected != 0 {
+		fmt.Printf("SKIP: %d (%d not selected)\n", skipNum, f.Results.CountDeselected)
+	} else if skipNum != 0 {
+		fmt.Printf("SKIP: %d\n", skipNum)
 	}
-	if err != nil {
-		f.AddError(err)
-	} else {
-		f.Results.AddSuccess(f.Results.CountTotal, true)
+	if f.Results.CountChecked != 0 {
+		fmt.Printf("CHECKED: %d\n", f.Results.CountChecked)
 	}
-}
-
-// DispatchTestCase dispatches a test case.
-func (f *DocRunner) DispatchTestCase(test *testDetails, lang, source string) {
-	var err error
-	switch lang {
-	case "python":
-		err = f.Starlark.Run(test, source)
-	default:
-		err = fmt.Errorf("unknown code language %s", lang)
+	if f.Results.CountXFail != 0 {
+		fmt.Printf("XFAIL: %d\n", f.Results.CountXFail)
 	}
-	if err != nil {
-		f.AddError(err)
-	} else {
-		f.Results.AddSuccess(f.Results.CountTotal, true)
+	if f.Results.CountIgnored != 0 {
+		fmt.Printf("IGNORED: %d\n", f.Results.CountIgnored)
 	}
 }
 
-// DispatchCommandCase dispatches a command.
-// TODO(dlong): Implementation is only a stub currently.
-func (f *DocRunner) DispatchCommandCase(cmd *commandDetails, lang, source string) {
-	var err error
-	switch lang {
-	case "shell":
-		err = f.CommandLine.Run(cmd, source)
-	default:
-		err = fmt.Errorf("unknown code language %s", lang)
-	}
-	if err != nil {
-		f.AddError(err)
-	} else {
-		f.Results.AddSuccess(f.Results.CountTotal, true)
-	}
+// GetResults returns the results from a run of docrun
+func (f *DocRunner) GetResults() RunResults {
+	return f.Results
 }
@@ -1,41 +1,37 @@
 package framework
 
 import (
-	"io"
+	"fmt"
+	"go/scanner"
+	"go/token"
 	"io/ioutil"
-
-	"github.com/gomarkdown/markdown"
-	"github.com/gomarkdown/markdown/ast"
-	"github.com/gomarkdown/markdown/html"
-
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/qri-io/dataset"
 )
 
 // The actual DocRun processor, given each node as they are parsed.
 var runner DocRunner
 
-// renderHook is called by markdown parser for each ast node.
-func renderHook(w io.Writer, node ast.Node, entering bool) (ast.WalkStatus, bool) {
-	// Only process nodes when they open up.
-	if entering {
-		runner.AddNode(node)
-	}
-	// Tell the parser to continue walking the ast normally.
-	return ast.GoToNext, false
-}
-
-func TestDocrunner(t *testing.T) {
-	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: renderHook,
-	}
-	renderer := html.NewRenderer(opts)
-	md, err := ioutil.ReadFile("testdata/doc.md")
+// runTestdata runs docrun over a markdown file in the testdata directory.
+func runTestdata(filename string) {
+	md, err := ioutil.ReadFile(filename)
 	if err != nil {
 		panic(err)
 	}
 	runner.Init()
-	_ = markdown.ToHTML([]byte(md), nil, renderer)
+	runner.RunDocument(md)
+}
+
+func TestDocrunner(t *testing.T) {
+	runTestdata("testdata/doc.md")
 	if runner.HasError() {
 		runner.ShowErrors()
 		t.Errorf("Docrunner encountered errors")
@@ -56,23 +52,617 @@ func TestDocrunner(t *testing.T) {
 }
 
 func TestErrorFilltype(t *testing.T) {
-	opts := html.RendererOptions{
-		Flags:          html.CommonFlags,
-		RenderNodeHook: renderHook,
-	}
-	renderer := html.NewRenderer(opts)
-	md, err := ioutil.ReadFile("testdata/error_filltype.md")
-	if err != nil {
-		panic(err)
-	}
-	runner.Init()
-	_ = markdown.ToHTML([]byte(md), nil, renderer)
+	runTestdata("testdata/error_filltype.md")
 	if !runner.HasError() {
 		t.Fatalf("Expected errors, did not encounter any")
 	}
-	err = runner.Errs[0]
-	expect := `case 1: path "bodypathz": not found in destination struct`
+	err := runner.Errs[0]
+	expect := `case test-markdown/1: path "bodypathz": not found in destination struct`
 	if err.Error() != expect {
 		t.Errorf("error didn't match, actual: \"%s\", expect: \"%s\"", err.Error(), expect)
 	}
 }
+
+func TestRegisterFilltype(t *testing.T) {
+	type size struct {
+		Width  int
+		Height int
+	}
+	RegisterFilltype("example.Size", func() interface{} { return &size{} })
+	defer delete(registeredFilltypes, "example.Size")
+
+	runTestdata("testdata/filltypes.md")
+	expect := []string{
+		`case configuration/3: path "formatt": not found in destination struct`,
+		`case configuration/4: filltype dataset.Readme needs a newer version of github.com/qri-io/dataset`,
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("errors mismatch, actual: %q, expect: %q", actual, expect)
+	}
+	res := runner.GetResults()
+	if res.CountSuccess != 7 {
+		t.Errorf("Expected 7 successful tests, got %d", res.CountSuccess)
+	}
+}
+
+func TestFilltypeFormats(t *testing.T) {
+	type size struct {
+		Width  int
+		Height int
+	}
+	RegisterFilltype("example.Sizes", func() interface{} { return &[]size{} })
+	defer delete(registeredFilltypes, "example.Sizes")
+
+	runTestdata("testdata/filltype_formats.md")
+	expectErrs := []string{
+		`case formats/3: path "bodyPathz": not found in destination struct`,
+		`case formats/6: expected an object for dataset.Dataset, got a list`,
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expectErrs) {
+		t.Errorf("errors mismatch, actual: %q, expect: %q", actual, expectErrs)
+	}
+	res := runner.GetResults()
+	if res.CountSuccess != 4 {
+		t.Errorf("Expected 4 successful tests, got %d", res.CountSuccess)
+	}
+
+	sizes := []size{}
+	err := fillValue([]interface{}{map[string]interface{}{"width": "wide"}}, &sizes)
+	expect := `at 0.Width: need int, got string: "wide"`
+	if err == nil || err.Error() != expect {
+		t.Errorf("error mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+	err = fillValue([]interface{}{}, &dataset.Dataset{})
+	expect = `expected an object for dataset.Dataset, got a list`
+	if err == nil || err.Error() != expect {
+		t.Errorf("error mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestErrorUnknownField(t *testing.T) {
+	runTestdata("testdata/error_unknown_field.md")
+	if len(runner.Errs) != 1 {
+		t.Fatalf("Expected 1 error, got %d: %v", len(runner.Errs), runner.Errs)
+	}
+	expect := `at docrun: unknown field "tset", did you mean "test"?`
+	if !strings.Contains(runner.Errs[0].Error(), expect) {
+		t.Errorf("error didn't match, actual: \"%s\", expect: \"%s\"", runner.Errs[0], expect)
+	}
+}
+
+func TestErrorExclusive(t *testing.T) {
+	runTestdata("testdata/error_exclusive.md")
+	expectErrs := []string{
+		`fields "pass", "filltype" are mutually exclusive, only one may be used`,
+		`source code block 2 has language "yaml" but fixture has lang "python"`,
+	}
+	if len(runner.Errs) != len(expectErrs) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectErrs), len(runner.Errs), runner.Errs)
+	}
+	for i, expect := range expectErrs {
+		if !strings.Contains(runner.Errs[i].Error(), expect) {
+			t.Errorf("error %d didn't match, actual: \"%s\", expect: \"%s\"", i, runner.Errs[i], expect)
+		}
+	}
+}
+
+func TestOrphanedFixtures(t *testing.T) {
+	runTestdata("testdata/error_orphan.md")
+	expectErrs := []string{
+		`docrun fixture at line 5 is followed by another fixture at line 9, without a code block in between`,
+		`docrun fixture at line 18 is separated from its code block by prose`,
+		`case test-markdown/unannotated-1: source code block 2 is not preceded by a docrun fixture`,
+		`docrun fixture at line 29 is separated from its code block by a heading`,
+		`case a-heading/unannotated-1: source code block 3 is not preceded by a docrun fixture`,
+		`docrun fixture at line 40 is at the end of the document, without a code block following it`,
+	}
+	if len(runner.Errs) != len(expectErrs) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectErrs), len(runner.Errs), runner.Errs)
+	}
+	for i, expect := range expectErrs {
+		if runner.Errs[i].Error() != expect {
+			t.Errorf("error %d didn't match, actual: \"%s\", expect: \"%s\"", i, runner.Errs[i], expect)
+		}
+	}
+	res := runner.GetResults()
+	if res.CountOrphaned != 4 {
+		t.Errorf("Expected 4 orphaned fixtures, got %d", res.CountOrphaned)
+	}
+	if res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+	if res.CountMissing != 2 {
+		t.Errorf("Expected 2 missing tests, got %d", res.CountMissing)
+	}
+
+	// HTML that isn't a fixture separates a fixture from its code block too.
+	runTestdata("testdata/error_orphan_html.md")
+	expectErrs = []string{
+		`docrun fixture at line 3 is separated from its code block by HTML`,
+		`case orphaned-by-html/unannotated-1: source code block 1 is not preceded by a docrun fixture`,
+		`docrun fixture at line 13 is separated from its code block by HTML`,
+		`case orphaned-by-html/unannotated-2: source code block 2 is not preceded by a docrun fixture`,
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expectErrs) {
+		t.Errorf("errors mismatch, actual: %q, expect: %q", actual, expectErrs)
+	}
+}
+
+func TestDocumentConfig(t *testing.T) {
+	runTestdata("testdata/doc_config.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountTotal != 2 {
+		t.Errorf("Expected 2 total tests, got %d", res.CountTotal)
+	}
+	if res.CountSuccess != 2 {
+		t.Errorf("Expected 2 successful tests, got %d", res.CountSuccess)
+	}
+	if res.CountTrivial != 1 {
+		t.Errorf("Expected 1 trivial test, got %d", res.CountTrivial)
+	}
+}
+
+func TestLanguages(t *testing.T) {
+	runTestdata("testdata/lang.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountSuccess != 5 {
+		t.Errorf("Expected 5 successful tests, got %d", res.CountSuccess)
+	}
+	expect := `case languages/5: language "python" is deprecated, use "starlark" instead`
+	if len(runner.Warnings) != 1 || runner.Warnings[0].Error() != expect {
+		t.Errorf("Expected warning \"%s\", got %v", expect, runner.Warnings)
+	}
+
+	_, err := parseDocumentConfig("docrun-config:\n  lang_aliases:\n    py: pyhton\n")
+	expect = `lang_aliases: "py" refers to unknown language "pyhton", expected one of: csv, go, golang, json, ` +
+		`python, shell, sky, star, starlark, toml, yaml`
+	if err == nil || err.Error() != expect {
+		t.Errorf("error mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestCheckSyntax(t *testing.T) {
+	runTestdata("testdata/check.md")
+	expect := []string{
+		"case syntax-checks/2: :2:15: undefined: helper",
+		"case syntax-checks/6: record on line 2: wrong number of fields",
+		"case syntax-checks/8: 6:26: undefined: count",
+		"case syntax-checks/10: 1:33: undefined: count",
+		"case syntax-checks/12: 1:1: undefined: framework",
+		"case syntax-checks/13: 1:11: undefined: framework.NewFilter",
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("errors mismatch, actual: %q, expect: %q", actual, expect)
+	}
+	res := runner.GetResults()
+	if res.CountChecked != 7 {
+		t.Errorf("Expected 7 checked tests, got %d", res.CountChecked)
+	}
+	if res.CountSuccess != 0 {
+		t.Errorf("Expected 0 successful tests, got %d", res.CountSuccess)
+	}
+}
+
+func TestFragmentErrorPositions(t *testing.T) {
+	source := "x := 1\n_ = x"
+	program, err := goProgram(&compileDetails{Template: "func"}, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		line   int
+		expect string
+	}{
+		{1, "bad import"},
+		{6, "2:1: bad import"},
+		{7, "bad import"},
+	}
+	for _, c := range cases {
+		errs := scanner.ErrorList{{Pos: token.Position{Line: c.line, Column: 1}, Msg: "bad import"}}
+		if err := fragmentError(errs, program, source); err.Error() != c.expect {
+			t.Errorf("line %d: error mismatch, actual: \"%s\", expect: \"%s\"", c.line, err, c.expect)
+		}
+	}
+}
+
+func TestDocumentFrontMatter(t *testing.T) {
+	runTestdata("testdata/doc_front_matter.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	if runner.DocConfig == nil || runner.DocConfig.Timeout != "5s" {
+		t.Fatalf("Expected front matter to set timeout, got %v", runner.DocConfig)
+	}
+	res := runner.GetResults()
+	if res.CountTotal != 1 {
+		t.Errorf("Expected 1 total test, got %d", res.CountTotal)
+	}
+	if res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+}
+
+func TestUnannotatedRules(t *testing.T) {
+	runTestdata("testdata/unannotated.md")
+	expectErrs := []string{
+		`case test-markdown/unannotated-3: unannotated code block 2: yaml: line 1: did not find expected ',' or ']'`,
+		`case test-markdown/unannotated-5: source code block 4 is not preceded by a docrun fixture`,
+	}
+	if len(runner.Errs) != len(expectErrs) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(expectErrs), len(runner.Errs), runner.Errs)
+	}
+	for i, expect := range expectErrs {
+		if runner.Errs[i].Error() != expect {
+			t.Errorf("error %d didn't match, actual: \"%s\", expect: \"%s\"", i, runner.Errs[i], expect)
+		}
+	}
+	res := runner.GetResults()
+	if res.CountTotal != 4 {
+		t.Errorf("Expected 4 total tests, got %d", res.CountTotal)
+	}
+	if res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+	if res.CountChecked != 1 {
+		t.Errorf("Expected 1 checked test, got %d", res.CountChecked)
+	}
+	if res.CountUnannotated != 3 {
+		t.Errorf("Expected 3 unannotated tests, got %d", res.CountUnannotated)
+	}
+	if res.CountIgnored != 1 {
+		t.Errorf("Expected 1 ignored test, got %d", res.CountIgnored)
+	}
+	if res.CountMissing != 1 {
+		t.Errorf("Expected 1 missing test, got %d", res.CountMissing)
+	}
+}
+
+func TestSkipAndXFail(t *testing.T) {
+	runTestdata("testdata/markers.md")
+	expect := `case test-markdown/3: source code block 3 passed, but is marked xfail: this one actually works`
+	if len(runner.Errs) != 1 || runner.Errs[0].Error() != expect {
+		t.Fatalf("Expected error \"%s\", got %v", expect, runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountSkipped != 1 {
+		t.Errorf("Expected 1 skipped test, got %d", res.CountSkipped)
+	}
+	if res.CountXFail != 1 {
+		t.Errorf("Expected 1 expected failure, got %d", res.CountXFail)
+	}
+	if res.Failures() != 1 {
+		t.Errorf("Expected 1 failure, got %d", res.Failures())
+	}
+}
+
+func TestSession(t *testing.T) {
+	runTestdata("testdata/session.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountSuccess != 3 {
+		t.Errorf("Expected 3 successful tests, got %d", res.CountSuccess)
+	}
+	if res.CountXFail != 1 {
+		t.Errorf("Expected 1 expected failure, got %d", res.CountXFail)
+	}
+}
+
+func TestErrorSession(t *testing.T) {
+	runTestdata("testdata/error_session.md")
+	expect := `case session-errors/1: docrun fixture at line 3: field "session" can only be used with "test", not "filltype"`
+	if len(runner.Errs) != 1 || runner.Errs[0].Error() != expect {
+		t.Fatalf("Expected error \"%s\", got %v", expect, runner.Errs)
+	}
+}
+
+func TestOnly(t *testing.T) {
+	runTestdata("testdata/markers_only.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+	if res.CountTrivial != 0 {
+		t.Errorf("Expected 0 trivial tests, got %d", res.CountTrivial)
+	}
+	if res.CountDeselected != 1 {
+		t.Errorf("Expected 1 deselected test, got %d", res.CountDeselected)
+	}
+}
+
+func TestCaseFilter(t *testing.T) {
+	filter, err := NewCaseFilter("^transform/", "network, slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.Filter = filter
+	runTestdata("testdata/filter.md")
+	runner.Filter = nil
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	res := runner.GetResults()
+	if res.CountTotal != 4 {
+		t.Errorf("Expected 4 total tests, got %d", res.CountTotal)
+	}
+	if res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+	if res.CountDeselected != 3 {
+		t.Errorf("Expected 3 deselected tests, got %d", res.CountDeselected)
+	}
+}
+
+func TestDuplicateCaseName(t *testing.T) {
+	runTestdata("testdata/error_duplicate_name.md")
+	expect := `case example: case name "example" is used more than once`
+	if len(runner.Errs) != 1 || runner.Errs[0].Error() != expect {
+		t.Fatalf("Expected error \"%s\", got %v", expect, runner.Errs)
+	}
+}
+
+func TestSlugify(t *testing.T) {
+	cases := []struct {
+		text   string
+		expect string
+	}{
+		{"Test markdown", "test-markdown"},
+		{"  Using `ds.set_body()`!  ", "using-ds-set-body"},
+		{"Step 2: Transform", "step-2-transform"},
+	}
+	for i, c := range cases {
+		if got := slugify(c.text); got != c.expect {
+			t.Errorf("case %d: mismatch, actual: \"%s\", expect: \"%s\"", i, got, c.expect)
+		}
+	}
+}
+
+func TestRecordCassette(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "hello from %s", r.URL.Path)
+	}))
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "docrun")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	doc := fmt.Sprintf(`<!--
+docrun:
+  test:
+    webproxy:
+      cassette: fixtures/hello.yaml
+    call: fetch()
+    actual: fetch()
+    expect: hello from /greeting
+-->
+`+"```starlark"+`
+load("http.star", "http")
+
+def fetch():
+  return http.get("%s/greeting").body()
+`+"```\n", server.URL)
+	path := filepath.Join(dir, "doc.md")
+	if err := ioutil.WriteFile(path, []byte(doc), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	runner.Init()
+	runner.Record = true
+	err = runner.RunFile(path)
+	runner.Record = false
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.HasError() {
+		t.Fatalf("recording: %s", runner.Errs[0])
+	}
+	recorded, err := ioutil.ReadFile(filepath.Join(dir, "fixtures/hello.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := fmt.Sprintf(`routes:
+- method: GET
+  url: %s/greeting
+  status: 200
+  headers:
+    Content-Type: text/plain
+  response: hello from /greeting
+`, server.URL)
+	if string(recorded) != expect {
+		t.Errorf("cassette mismatch, actual: %q, expect: %q", recorded, expect)
+	}
+
+	// Replaying doesn't need the server.
+	server.Close()
+	runner.Init()
+	err = runner.RunFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runner.HasError() {
+		t.Errorf("replaying: %s", runner.Errs[0])
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	start := time.Now()
+	runTestdata("testdata/timeout.md")
+	expect := []string{
+		`case timeout/2: timed out after 50ms`,
+		`case timeout/3: session slow ended when case timeout/2 timed out`,
+		`case timeout/5: session fast ended when case timeout/2 timed out`,
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("errors mismatch, actual: %q, expect: %q", actual, expect)
+	}
+	if runner.Results.CountSuccess != 2 {
+		t.Errorf("expected 2 successes, got %d", runner.Results.CountSuccess)
+	}
+	// The case that timed out is stopped, rather than left running.
+	if elapsed := time.Since(start); elapsed > cancelGrace {
+		t.Errorf("expected the case to be stopped, took %s", elapsed)
+	}
+}
+
+// TestDocuments runs documents whose failing cases are expected, checking the exact errors and
+// how many cases succeed.
+func TestDocuments(t *testing.T) {
+	cases := []struct {
+		path      string
+		expect    []string
+		successes int
+	}{
+		{
+			"testdata/validate.md",
+			[]string{
+				`case validation/2: at path: invalid path "QmMissingStore", expected a content-addressed path like "/ipfs/QmHash"
+at name: invalid name "2019 population", names must start with a letter and only contain letters, numbers and underscores
+at structure.format: unknown format "tsv", expected one of: cbor, json, csv, xlsx
+at body.1.1: "many" type should be integer`,
+				`case validation/3: at schema: invalid JSON Schema: error unmarshaling type from json: "table" is not a valid type`,
+			},
+			1,
+		},
+		{
+			"testdata/schema.md",
+			[]string{
+				`case schemas/2: at /: {"name":"registry","... "url" value is required
+at /port: 0 must be greater than or equal to 1.000000`,
+				`case schemas/3: fixture file "schemas/missing.json" not found`,
+			},
+			1,
+		},
+		{
+			"testdata/go.md",
+			[]string{
+				"case go-examples/3: compiling: ./main.go:6:11: undefined: framework.NewFilter",
+			},
+			3,
+		},
+		{
+			"testdata/case_ids.md",
+			[]string{
+				`case example/2: during Call: :1:1: undefined: missing`,
+			},
+			1,
+		},
+		{
+			"testdata/roundtrip.md",
+			[]string{
+				`case round-trips/2: at entries: changed from 2.5 to 2`,
+				`case round-trips/4: at secrets: value {"api_key":"hunter2"} was lost`,
+			},
+			2,
+		},
+		{
+			"testdata/web_proxy.md",
+			[]string{
+				`case web-proxy/3: during Call: Get "https://example.com/missing?page=1": no WebProxy route matches, expected one of: * https://example.com/a, POST prefix https://example.com/b/`,
+				`case web-proxy/4: docrun fixture at line 75: at test.webproxy.routes.0: fields "url", "prefix" are mutually exclusive, only one may be used
+at test.webproxy.routes.0.method: unknown method "fetch", expected one of: GET, POST, PUT, DELETE, PATCH, OPTIONS`,
+			},
+			3,
+		},
+		{
+			"testdata/session_webproxy.md",
+			[]string{
+				`case webproxy-sessions/3: during Call: Get "https://api.example.com/items": http requires a WebProxy`,
+			},
+			2,
+		},
+		{
+			"testdata/cassette.md",
+			[]string{
+				`case cassettes/2: fixture file "cassettes/missing.yaml" not found`,
+			},
+			1,
+		},
+		{
+			"testdata/qri.md",
+			[]string{
+				`case qri-module/3: during Call: unknown dataset "peer/books", expected one of: peer/movies, peer/population`,
+			},
+			2,
+		},
+		{
+			"testdata/lifecycle.md",
+			[]string{
+				`case lifecycle/5: transform should not return anything`,
+				`case lifecycle/6: lifecycle requires a download or transform function`,
+				`case lifecycle/10: transform should not return anything`,
+			},
+			7,
+		},
+		{
+			"testdata/expect_dataset.md",
+			[]string{
+				"case expect-dataset/2: dataset mismatch\n  at body.1: actual \"Oslo\", expect \"Lima\"\n  at meta.description: missing, expect \"Largest cities\"\n  at meta.title: actual \"Population\", expect \"Populations\"",
+				`case expect-dataset/3: docrun fixture at line 40: at test.expect_dataset: unknown component "commit", expected one of: body, meta, structure`,
+			},
+			2,
+		},
+		{
+			"testdata/asserts.md",
+			[]string{
+				"case asserts/3: asserts.0: test case failure\n  actual: a\n  expect: b\nasserts.2: test case failure\n  actual: b\n  expect: a",
+				`case asserts/4: docrun fixture at line 61: at test.asserts.0: field "expect" is required`,
+				`case asserts/5: during Teardown: transform error: "cleanup failed"`,
+				`case asserts/7: during Setup: transform error: "setup failed"`,
+			},
+			4,
+		},
+		{
+			"testdata/modules.md",
+			[]string{
+				`case modules/2: running code block: cannot load pandas.star: module "pandas.star" is not defined, expected one of: bsoup.star, encoding/base64.star, encoding/csv.star, encoding/json.star, geo.star, html.star, http.star, math.star, qri.star, re.star, time.star, xlsx.star, zipfile.star`,
+				`case modules/3: during Call: transform error: "no rows"`,
+			},
+			1,
+		},
+		{
+			"testdata/modules_allow.md",
+			[]string{
+				`case allowed-modules/1: running code block: cannot load http.star: module "http.star" is not allowed, expected one of: encoding/json.star`,
+			},
+			0,
+		},
+	}
+	stderr := os.Stderr
+	for _, c := range cases {
+		runner.Init()
+		if err := runner.RunFile(c.path); err != nil {
+			t.Fatal(err)
+		}
+		if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, c.expect) {
+			t.Errorf("%s: errors mismatch, actual: %q, expect: %q", c.path, actual, c.expect)
+		}
+		if runner.Results.CountSuccess != c.successes {
+			t.Errorf("%s: expected %d successes, got %d", c.path, c.successes, runner.Results.CountSuccess)
+		}
+		// Output captured from starlark is restored afterwards.
+		if os.Stderr != stderr {
+			t.Errorf("%s: os.Stderr wasn't restored", c.path)
+		}
+	}
+}
+
+// errorStrings returns the text of each error, to compare against those expected.
+func errorStrings(errs []error) []string {
+	strs := []string{}
+	for _, err := range errs {
+		strs = append(strs, err.Error())
+	}
+	return strs
+}
@@ -0,0 +1,213 @@
+package framework
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// compileDetails holds metadata about code that is compiled, and possibly run
+type compileDetails struct {
+	// How to turn the code block into a program: "" if it's already a whole program, "main" to
+	// use it as the body of main, or "func" to use it as the body of a function that isn't called
+	Template string
+	// Packages to import, for templates
+	Imports []string
+	// Whether to run the program after it compiles, which is implied by Expect
+	Run bool
+	// Expected output of the program
+	Expect *string
+}
+
+// compileTemplates are the allowed values for compileDetails.Template
+var compileTemplates = []string{"", "main", "func"}
+
+// checkRequired makes sure the compile details can be used together.
+func (d *compileDetails) checkRequired() error {
+	if !containsString(compileTemplates, d.Template) {
+		return fmt.Errorf("at compile: unknown template \"%s\", expected one of: main, func",
+			d.Template)
+	}
+	if d.Template == "" && len(d.Imports) > 0 {
+		return fmt.Errorf("at compile: field \"imports\" can only be used with a template")
+	}
+	if d.Template == "func" && (d.Run || d.Expect != nil) {
+		return fmt.Errorf("at compile: a \"func\" template can't be run")
+	}
+	return nil
+}
+
+// goRunner compiles code blocks written in go using the local toolchain. Programs are built as
+// if they were inside the go module containing the document, so that they can import its
+// packages, and are checked against the current version of its API. Nothing is written to the
+// module: the program's files are in a temporary directory, overlaid onto the module by go build.
+type goRunner struct {
+	// Temporary directory the program is written to, for the case being run
+	dir string
+	// Root of the document's module, or "" if it isn't in one
+	root string
+}
+
+// overlayDir is where the program appears to be inside the document's module, relative to its
+// root. Directories beginning with "." are ignored by patterns like "./...".
+const overlayDir = ".docrun"
+
+// Prepare makes a temporary directory for the program.
+func (r *goRunner) Prepare(c *CaseContext) error {
+	dir, err := ioutil.TempDir("", "docrun-")
+	if err != nil {
+		return err
+	}
+	r.dir = dir
+	r.root = findModuleRoot(filepath.Dir(c.Path))
+	if r.root == "" {
+		// Outside of a module, the program can only use the standard library.
+		return ioutil.WriteFile(filepath.Join(dir, "go.mod"), []byte("module docrun\n"), 0644)
+	}
+	return nil
+}
+
+// Run compiles the program, then runs it and compares its output if needed.
+func (r *goRunner) Run(c *CaseContext) error {
+	details, _ := c.Config.(*compileDetails)
+	if details == nil {
+		details = &compileDetails{}
+	}
+	program, err := goProgram(details, c.Source)
+	if err != nil {
+		return err
+	}
+	err = ioutil.WriteFile(filepath.Join(r.dir, "main.go"), []byte(program), 0644)
+	if err != nil {
+		return err
+	}
+	args, err := r.buildArgs()
+	if err != nil {
+		return err
+	}
+	ctx := c.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	binary := filepath.Join(r.dir, "example")
+	build := exec.CommandContext(ctx, "go", append([]string{"build", "-o", binary}, args...)...)
+	build.Dir = r.dir
+	if r.root != "" {
+		build.Dir = r.root
+	}
+	if out, err := build.CombinedOutput(); err != nil {
+		return fmt.Errorf("compiling: %s", compilerErrors(string(out), r.dir))
+	}
+	if !details.Run && details.Expect == nil {
+		return nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	run := exec.CommandContext(ctx, binary)
+	run.Dir = filepath.Dir(c.Path)
+	run.Stdout = &stdout
+	run.Stderr = &stderr
+	if err := run.Run(); err != nil {
+		return fmt.Errorf("running: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	if details.Expect == nil {
+		return nil
+	}
+	actual := strings.TrimSpace(stdout.String())
+	expect := strings.TrimSpace(*details.Expect)
+	if actual != expect {
+		tmpl := `test case failure
+  actual: %s
+  expect: %s`
+		return fmt.Errorf(tmpl, actual, expect)
+	}
+	return nil
+}
+
+// buildArgs returns the arguments for go build to build the program. Inside a module, an overlay
+// makes main.go appear to be in overlayDir.
+func (r *goRunner) buildArgs() ([]string, error) {
+	if r.root == "" {
+		return []string{"."}, nil
+	}
+	overlay := map[string]map[string]string{
+		"Replace": {filepath.Join(r.root, overlayDir, "main.go"): filepath.Join(r.dir, "main.go")},
+	}
+	data, err := json.Marshal(overlay)
+	if err != nil {
+		return nil, err
+	}
+	overlayFile := filepath.Join(r.dir, "overlay.json")
+	if err := ioutil.WriteFile(overlayFile, data, 0644); err != nil {
+		return nil, err
+	}
+	return []string{"-overlay", overlayFile, "./" + overlayDir}, nil
+}
+
+// Cleanup removes the program's directory.
+func (r *goRunner) Cleanup(c *CaseContext) error {
+	if r.dir == "" {
+		return nil
+	}
+	err := os.RemoveAll(r.dir)
+	r.dir = ""
+	return err
+}
+
+// compilerErrors returns the errors printed by go build, without the lines naming the package,
+// and with the program's files named relative to their temporary directory, dir.
+func compilerErrors(out, dir string) string {
+	lines := []string{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		if !strings.HasPrefix(line, "# ") {
+			lines = append(lines, strings.Replace(line, dir+string(filepath.Separator), "./", -1))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// goProgram returns the source of a whole program, using the template to wrap the code block.
+func goProgram(details *compileDetails, source string) (string, error) {
+	if details.Template == "" {
+		return source, nil
+	}
+	buf := bytes.Buffer{}
+	buf.WriteString("package main\n\n")
+	for _, pkg := range details.Imports {
+		fmt.Fprintf(&buf, "import %q\n", pkg)
+	}
+	switch details.Template {
+	case "main":
+		fmt.Fprintf(&buf, "\nfunc main() {\n%s\n}\n", source)
+	case "func":
+		fmt.Fprintf(&buf, "\nfunc example() {\n%s\n}\n\nfunc main() {}\n", source)
+	default:
+		return "", fmt.Errorf("unknown template \"%s\"", details.Template)
+	}
+	return buf.String(), nil
+}
+
+// findModuleRoot returns the closest directory containing a go.mod file, or the empty string if
+// there isn't one.
+func findModuleRoot(dir string) string {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return ""
+	}
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,92 @@
+package framework
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// languages maps each code fence language that docrun knows about to the canonical language
+// used to run it.
+var languages = map[string]string{
+	"starlark": "starlark",
+	"star":     "starlark",
+	"sky":      "starlark",
+	"python":   "starlark",
+	"shell":    "shell",
+	"go":       "go",
+	"golang":   "go",
+	"json":     "json",
+	"yaml":     "yaml",
+	"toml":     "toml",
+	"csv":      "csv",
+}
+
+// legacyLanguages still work, but are misleading, so using them causes a warning that suggests
+// the language to use instead. Starlark code looks like python, but isn't.
+var legacyLanguages = map[string]string{
+	"python": "starlark",
+}
+
+// checkLangAliases makes sure each alias refers to a language docrun knows about.
+func checkLangAliases(aliases map[string]string) error {
+	for alias, target := range aliases {
+		if _, ok := languages[target]; !ok {
+			return fmt.Errorf("lang_aliases: \"%s\" refers to unknown language \"%s\", expected one of: %s",
+				alias, target, strings.Join(knownLanguages(), ", "))
+		}
+	}
+	return nil
+}
+
+// knownLanguages returns the sorted names of each language docrun knows about.
+func knownLanguages() []string {
+	names := make([]string, 0, len(languages))
+	for name := range languages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolveLang returns the canonical language for a code fence language, and the language that
+// replaces it if it is a legacy language. Aliases from the document configuration take
+// precedence over those from the project configuration. Unknown languages are returned unchanged.
+func (f *DocRunner) resolveLang(lang string) (canonical, replacement string) {
+	if target, ok := f.langAlias(lang); ok {
+		lang = target
+	}
+	canonical, ok := languages[lang]
+	if !ok {
+		return lang, ""
+	}
+	return canonical, legacyLanguages[lang]
+}
+
+// langAlias looks up a language alias from the document, then the project, configuration.
+func (f *DocRunner) langAlias(lang string) (string, bool) {
+	if f.DocConfig != nil {
+		if target, ok := f.DocConfig.LangAliases[lang]; ok {
+			return target, true
+		}
+	}
+	target, ok := f.project().LangAliases[lang]
+	return target, ok
+}
+
+// sameLang returns whether two languages are the same, once resolved.
+func (f *DocRunner) sameLang(a, b string) bool {
+	a, _ = f.resolveLang(a)
+	b, _ = f.resolveLang(b)
+	return a == b
+}
+
+// caseLang resolves the language of the case being run, warning if it is a legacy language.
+func (f *DocRunner) caseLang(lang string) string {
+	canonical, replacement := f.resolveLang(lang)
+	if replacement != "" {
+		f.AddWarning(fmt.Errorf("language \"%s\" is deprecated, use \"%s\" instead", lang,
+			replacement))
+	}
+	return canonical
+}
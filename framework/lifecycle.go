@@ -0,0 +1,124 @@
+package framework
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/qri-io/qri/startf/context"
+	stards "github.com/qri-io/qri/startf/ds"
+	starutil "github.com/qri-io/starlib/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// downloadSetupPrefix begins a line of Setup that assigns ctx.download, which can't be done by
+// running it since ctx is read-only. The rest of the line is the expression to assign.
+const downloadSetupPrefix = "ctx.download = "
+
+// loadDownloadFixture reads the value of ctx.download from a fixture file. JSON, TOML and YAML
+// files are decoded, any other file is used as a string.
+func loadDownloadFixture(path string) (interface{}, error) {
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	if lang == "yml" {
+		lang = "yaml"
+	}
+	switch lang {
+	case "json", "toml", "yaml":
+		return decodeData(lang, string(source))
+	default:
+		return string(source), nil
+	}
+}
+
+// setContextValues adds the test's config and secrets to those of the session's ctx, for
+// ctx.get_config and ctx.get_secret to return.
+func (s *starlarkSession) setContextValues(details *testDetails) {
+	for key, val := range details.Config {
+		s.config[key] = jsonCompatible(val)
+	}
+	for key, val := range details.Secrets {
+		s.secrets[key] = jsonCompatible(val)
+	}
+}
+
+// setDownload assigns ctx.download from the test's fixture file or expression, if it has one.
+// It returns whether ctx.download was assigned.
+func setDownload(thread *starlark.Thread, details *testDetails, ctx *context.Context,
+	environment starlark.StringDict) (bool, error) {
+	if details.downloadFixture != nil {
+		val, err := starutil.Marshal(jsonCompatible(details.downloadFixture))
+		if err != nil {
+			return false, fmt.Errorf("download_file: %s", err)
+		}
+		ctx.SetResult("download", val)
+		return true, nil
+	}
+	if details.Download != "" {
+		val, err := starlark.Eval(thread, "", details.Download, environment)
+		if err != nil {
+			return false, fmt.Errorf("during Download: %s", err)
+		}
+		ctx.SetResult("download", val)
+		return true, nil
+	}
+	return false, nil
+}
+
+// runLifecycle runs the special functions of a qri transform in the same order as qri does:
+// download(ctx), with its result assigned to ctx.download, then transform(ds, ctx). The download
+// function isn't called if ctx.download was already assigned by the test.
+func runLifecycle(thread *starlark.Thread, ds *stards.Dataset, ctx *context.Context,
+	environment starlark.StringDict, downloaded bool) error {
+	download, hasDownload := environment["download"].(*starlark.Function)
+	transform, hasTransform := environment["transform"].(*starlark.Function)
+	if !hasDownload && !hasTransform {
+		return fmt.Errorf("lifecycle requires a download or transform function")
+	}
+	if hasDownload && !downloaded {
+		log.Info("running download...")
+		val, err := starlark.Call(thread, download, starlark.Tuple{ctx.Struct()}, nil)
+		if err != nil {
+			return fmt.Errorf("during download: %s", err)
+		}
+		ctx.SetResult("download", val)
+	}
+	if hasTransform {
+		log.Info("running transform...")
+		val, err := starlark.Call(thread, transform, starlark.Tuple{ds.Methods(), ctx.Struct()}, nil)
+		if err != nil {
+			return fmt.Errorf("during transform: %s", err)
+		}
+		// More of a lint rule: transform should not return anything.
+		if val != starlark.None {
+			return fmt.Errorf("transform should not return anything")
+		}
+	}
+	return nil
+}
+
+// calledFunction returns the name of the function called by the last statement of the source, or
+// "" if it isn't a call to a named function.
+func calledFunction(source string) string {
+	file, err := syntax.Parse("", source, 0)
+	if err != nil || len(file.Stmts) == 0 {
+		return ""
+	}
+	last, ok := file.Stmts[len(file.Stmts)-1].(*syntax.ExprStmt)
+	if !ok {
+		return ""
+	}
+	call, ok := last.X.(*syntax.CallExpr)
+	if !ok {
+		return ""
+	}
+	if ident, ok := call.Fn.(*syntax.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
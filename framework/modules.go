@@ -0,0 +1,131 @@
+package framework
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/qri-io/starlib"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+)
+
+// starlarkModules are the modules that starlark code can load, the same as in a qri transform.
+// "http.star" and "qri.star" are mocked, the rest are the real modules from starlib.
+var starlarkModules = []string{
+	"bsoup.star",
+	"encoding/base64.star",
+	"encoding/csv.star",
+	"encoding/json.star",
+	"geo.star",
+	"html.star",
+	"http.star",
+	"math.star",
+	"qri.star",
+	"re.star",
+	"time.star",
+	"xlsx.star",
+	"zipfile.star",
+}
+
+// moduleDetails restricts which starlark modules can be loaded
+type moduleDetails struct {
+	// Modules that can be loaded, or every module if empty
+	Allow []string
+	// Modules that can't be loaded
+	Deny []string
+}
+
+// checkModules makes sure the allowed and denied modules are known.
+func (m *moduleDetails) checkModules() error {
+	if m == nil {
+		return nil
+	}
+	for _, field := range []struct {
+		name    string
+		modules []string
+	}{{"allow", m.Allow}, {"deny", m.Deny}} {
+		for _, module := range field.modules {
+			if !containsString(starlarkModules, module) {
+				return fmt.Errorf("modules.%s: unknown module \"%s\", expected one of: %s", field.name,
+					module, strings.Join(starlarkModules, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// available returns the modules that can be loaded.
+func (m *moduleDetails) available() []string {
+	if m == nil {
+		return starlarkModules
+	}
+	modules := []string{}
+	for _, module := range starlarkModules {
+		if len(m.Allow) > 0 && !containsString(m.Allow, module) {
+			continue
+		}
+		if containsString(m.Deny, module) {
+			continue
+		}
+		modules = append(modules, module)
+	}
+	return modules
+}
+
+// modules returns the restrictions on starlark modules for a document, which take precedence
+// over those of the project.
+func (f *DocRunner) modules() *moduleDetails {
+	if f.DocConfig != nil && f.DocConfig.Modules != nil {
+		return f.DocConfig.Modules
+	}
+	return f.project().Modules
+}
+
+// NewMockModuleLoader returns a ModuleLoader to load the modules a test is allowed to use, with
+// mock versions of http and qri that use the test's mock http responses and datasets
+func NewMockModuleLoader(details *testDetails) ModuleLoader {
+	return newModuleLoader(details, &caseTransport{details: details})
+}
+
+// newModuleLoader returns a ModuleLoader whose http module sends requests with the transport.
+func newModuleLoader(details *testDetails, transport http.RoundTripper) ModuleLoader {
+	return func(thread *starlark.Thread, module string) (starlark.StringDict, error) {
+		available := details.modules.available()
+		if !containsString(available, module) {
+			if containsString(starlarkModules, module) {
+				return nil, fmt.Errorf("module \"%s\" is not allowed, expected one of: %s", module,
+					strings.Join(available, ", "))
+			}
+			return nil, fmt.Errorf("module \"%s\" is not defined, expected one of: %s", module,
+				strings.Join(available, ", "))
+		}
+		switch module {
+		case "http.star":
+			m := &MockHTTPModule{transport: transport}
+			return starlark.StringDict{"http": m.Struct()}, nil
+		case "qri.star":
+			m := &MockQriModule{datasets: details.datasets}
+			return starlark.StringDict{"qri": m.Struct()}, nil
+		default:
+			return starlib.Loader(thread, module)
+		}
+	}
+}
+
+// useTransformDialect enables the same language features as a qri transform: floating point
+// numbers, sets and lambdas.
+func useTransformDialect() {
+	resolve.AllowFloat = true
+	resolve.AllowSet = true
+	resolve.AllowLambda = true
+}
+
+// starlarkError halts the program with an error, like `error` in a qri transform
+func starlarkError(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg starlark.Value
+	if err := starlark.UnpackPositionalArgs("error", args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("transform error: %s", msg)
+}
@@ -0,0 +1,236 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/dataset"
+	"github.com/qri-io/qfs"
+	stards "github.com/qri-io/qri/startf/ds"
+	starutil "github.com/qri-io/starlib/util"
+	"go.starlark.net/starlark"
+	"go.starlark.net/starlarkstruct"
+)
+
+// checkDatasetRefs makes sure each declared dataset has a reference like "peername/name".
+func checkDatasetRefs(datasets map[string]interface{}) error {
+	refs := make([]string, 0, len(datasets))
+	for ref := range datasets {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	for _, ref := range refs {
+		parts := strings.Split(ref, "/")
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("datasets: invalid reference \"%s\", expected \"peername/name\"", ref)
+		}
+		switch datasets[ref].(type) {
+		case string, map[interface{}]interface{}, map[string]interface{}:
+		default:
+			return fmt.Errorf("datasets: %s must be a dataset, or the name of a file containing one", ref)
+		}
+	}
+	return nil
+}
+
+// prepareTest collects the datasets declared by the project and the document for a test,
+// reading those given as fixture files, along with the modules it can load and its download
+// fixture. Datasets declared by the document take precedence.
+func (f *DocRunner) prepareTest(test *testDetails) error {
+	datasets := map[string]interface{}{}
+	project := f.project()
+	for ref, val := range project.Datasets {
+		data, err := loadDatasetFixture(val, func(name string) (string, error) {
+			return project.ResolveFixture(project.dir, name)
+		})
+		if err != nil {
+			return fmt.Errorf("dataset %s: %s", ref, err)
+		}
+		datasets[ref] = data
+	}
+	if f.DocConfig != nil {
+		for ref, val := range f.DocConfig.Datasets {
+			data, err := loadDatasetFixture(val, func(name string) (string, error) {
+				return project.ResolveFixture(f.docDir(), name)
+			})
+			if err != nil {
+				return fmt.Errorf("dataset %s: %s", ref, err)
+			}
+			datasets[ref] = data
+		}
+	}
+	test.datasets = datasets
+	test.modules = f.modules()
+	if test.DownloadFile != "" {
+		path, err := project.ResolveFixture(f.docDir(), test.DownloadFile)
+		if err != nil {
+			return err
+		}
+		test.downloadFixture, err = loadDownloadFixture(path)
+		if err != nil {
+			return fmt.Errorf("download_file: %s", err)
+		}
+	}
+	return nil
+}
+
+// loadDatasetFixture returns a declared dataset, reading it from a JSON or YAML file if it is the
+// name of one.
+func loadDatasetFixture(val interface{}, resolve func(name string) (string, error)) (interface{}, error) {
+	name, ok := val.(string)
+	if !ok {
+		return val, nil
+	}
+	path, err := resolve(name)
+	if err != nil {
+		return nil, err
+	}
+	source, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	lang := strings.TrimPrefix(filepath.Ext(path), ".")
+	if lang == "yml" {
+		lang = "yaml"
+	}
+	return decodeData(lang, string(source))
+}
+
+// newFixtureDataset fills a dataset from fixture data. An inline body becomes the dataset's body
+// file, encoded as JSON, so that it can be read the same way as a stored dataset.
+func newFixtureDataset(ref string, data interface{}) (*dataset.Dataset, error) {
+	ds := &dataset.Dataset{}
+	if err := fillValue(data, ds); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(ref, "/")
+	if ds.Peername == "" {
+		ds.Peername = parts[0]
+	}
+	if ds.Name == "" {
+		ds.Name = parts[1]
+	}
+	if ds.Body != nil {
+		body, err := json.Marshal(jsonCompatible(ds.Body))
+		if err != nil {
+			return nil, err
+		}
+		if ds.Structure == nil {
+			ds.Structure = &dataset.Structure{}
+		}
+		ds.Structure.Format = "json"
+		if ds.Structure.Schema == nil {
+			ds.Structure.Schema = dataset.BaseSchemaArray
+			if _, ok := jsonCompatible(ds.Body).(map[string]interface{}); ok {
+				ds.Structure.Schema = dataset.BaseSchemaObject
+			}
+		}
+		ds.SetBodyFile(qfs.NewMemfileBytes("body.json", body))
+		ds.Body = nil
+	}
+	return ds, nil
+}
+
+// MockQriModule is a module for mocking out qri functionality, using datasets declared in the
+// document or project configuration in place of a qri repository.
+type MockQriModule struct {
+	datasets map[string]interface{}
+}
+
+// Struct returns a starlark struct with methods
+func (m *MockQriModule) Struct() *starlarkstruct.Struct {
+	return starlarkstruct.FromStringDict(starlarkstruct.Default, m.StringDict())
+}
+
+// StringDict returns the module as a dictionary keyed by strings
+func (m *MockQriModule) StringDict() starlark.StringDict {
+	return starlark.StringDict{
+		"list_datasets": starlark.NewBuiltin("list_datasets", m.listDatasets),
+		"load_dataset":  starlark.NewBuiltin("load_dataset", m.loadDataset),
+		"get_dataset":   starlark.NewBuiltin("get_dataset", m.getDataset),
+	}
+}
+
+// refs returns the references of the declared datasets, in order
+func (m *MockQriModule) refs() []string {
+	refs := make([]string, 0, len(m.datasets))
+	for ref := range m.datasets {
+		refs = append(refs, ref)
+	}
+	sort.Strings(refs)
+	return refs
+}
+
+// dataset makes a new copy of a declared dataset, so that changes made by one test aren't seen by
+// others. A reference may include a path after "@", which is ignored.
+func (m *MockQriModule) dataset(ref string) (*dataset.Dataset, error) {
+	if at := strings.Index(ref, "@"); at != -1 {
+		ref = ref[:at]
+	}
+	data, ok := m.datasets[ref]
+	if !ok {
+		if len(m.datasets) == 0 {
+			return nil, fmt.Errorf("unknown dataset \"%s\", no datasets are declared in the "+
+				"document or project configuration", ref)
+		}
+		return nil, fmt.Errorf("unknown dataset \"%s\", expected one of: %s", ref,
+			strings.Join(m.refs(), ", "))
+	}
+	return newFixtureDataset(ref, data)
+}
+
+// listDatasets returns the references of the declared datasets, including their paths if they
+// have them
+func (m *MockQriModule) listDatasets(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	ls := &starlark.List{}
+	for _, ref := range m.refs() {
+		ds, err := m.dataset(ref)
+		if err != nil {
+			return starlark.None, fmt.Errorf("dataset %s: %s", ref, err)
+		}
+		if ds.Path != "" {
+			ref = fmt.Sprintf("%s@%s%s", ref, ds.ProfileID, ds.Path)
+		}
+		ls.Append(starlark.String(ref))
+	}
+	return ls, nil
+}
+
+// loadDataset returns a declared dataset with the same methods as `ds`, like load_dataset does
+// in a qri transform
+func (m *MockQriModule) loadDataset(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var ref starlark.String
+	if err := starlark.UnpackArgs("load_dataset", args, kwargs, "ref", &ref); err != nil {
+		return starlark.None, err
+	}
+	ds, err := m.dataset(ref.GoString())
+	if err != nil {
+		return starlark.None, err
+	}
+	return stards.NewDataset(ds, nil).Methods(), nil
+}
+
+// getDataset returns the components of a declared dataset as a dictionary, without its body
+func (m *MockQriModule) getDataset(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var ref starlark.String
+	if err := starlark.UnpackArgs("get_dataset", args, kwargs, "ref", &ref); err != nil {
+		return starlark.None, err
+	}
+	ds, err := m.dataset(ref.GoString())
+	if err != nil {
+		return starlark.None, err
+	}
+	data, err := json.Marshal(ds)
+	if err != nil {
+		return starlark.None, err
+	}
+	var fields interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return starlark.None, err
+	}
+	return starutil.Marshal(fields)
+}
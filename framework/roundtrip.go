@@ -0,0 +1,155 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+)
+
+// checkRoundtrip encodes a filled structure, and compares the result to the data it was filled
+// from, reporting fields that were lost or altered along the way. Fields that the structure
+// adds, such as those with default values, are fine.
+func checkRoundtrip(data interface{}, value interface{}) error {
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("encoding: %s", err)
+	}
+	var encoded interface{}
+	err = json.Unmarshal(bytes, &encoded)
+	if err != nil {
+		return fmt.Errorf("decoding: %s", err)
+	}
+	collector := fill.NewErrorCollector()
+	compareRoundtrip(jsonCompatible(data), encoded, collector)
+	return collector.AsSingleError()
+}
+
+// compareRoundtrip compares original data to the same data after it has been filled and encoded.
+func compareRoundtrip(original, encoded interface{}, collector *fill.ErrorCollector) {
+	switch orig := original.(type) {
+	case map[string]interface{}:
+		// Components with only a path are encoded as the path, which is the same reference.
+		if path, ok := encoded.(string); ok && isReference(orig, path) {
+			return
+		}
+		enc, ok := encoded.(map[string]interface{})
+		if !ok {
+			collector.Add(fmt.Errorf("changed from %s to %s", showValue(original), showValue(encoded)))
+			return
+		}
+		keys := make([]string, 0, len(orig))
+		for key := range orig {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			collector.PushField(key)
+			// Fields are filled case-insensitively.
+			if val, ok := lookupFold(enc, key); ok {
+				compareRoundtrip(orig[key], val, collector)
+			} else if !isZeroValue(orig[key]) {
+				collector.Add(fmt.Errorf("value %s was lost", showValue(orig[key])))
+			}
+			collector.PopField()
+		}
+	case []interface{}:
+		enc, ok := encoded.([]interface{})
+		if !ok || len(enc) != len(orig) {
+			collector.Add(fmt.Errorf("changed from %s to %s", showValue(original), showValue(encoded)))
+			return
+		}
+		for i := range orig {
+			collector.PushField(fmt.Sprintf("%d", i))
+			compareRoundtrip(orig[i], enc[i], collector)
+			collector.PopField()
+		}
+	default:
+		if !sameScalar(original, encoded) {
+			collector.Add(fmt.Errorf("changed from %s to %s", showValue(original), showValue(encoded)))
+		}
+	}
+}
+
+// isReference returns whether the data is a component with only a path, which is the given path.
+func isReference(data map[string]interface{}, path string) bool {
+	for key, val := range data {
+		if strings.EqualFold(key, "path") {
+			if val != path {
+				return false
+			}
+		} else if !isZeroValue(val) {
+			return false
+		}
+	}
+	return path != ""
+}
+
+// lookupFold finds a key in a map, ignoring case.
+func lookupFold(m map[string]interface{}, key string) (interface{}, bool) {
+	if val, ok := m[key]; ok {
+		return val, true
+	}
+	for k, val := range m {
+		if strings.EqualFold(k, key) {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// sameScalar returns whether two scalars are equal, treating numbers of different types as equal
+// if they have the same value, since decoders differ in which types they use.
+func sameScalar(a, b interface{}) bool {
+	if x, ok := toFloat(a); ok {
+		y, ok := toFloat(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// toFloat converts a number of any type to a float64.
+func toFloat(val interface{}) (float64, bool) {
+	switch v := val.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// isZeroValue returns whether a value is empty, which encoders are free to leave out.
+func isZeroValue(val interface{}) bool {
+	if val == nil {
+		return true
+	}
+	if num, ok := toFloat(val); ok {
+		return num == 0
+	}
+	v := reflect.ValueOf(val)
+	switch v.Kind() {
+	case reflect.String, reflect.Slice, reflect.Map:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	}
+	return false
+}
+
+// showValue formats a value for an error message.
+func showValue(val interface{}) string {
+	bytes, err := json.Marshal(val)
+	if err != nil {
+		return fmt.Sprintf("%v", val)
+	}
+	return string(bytes)
+}
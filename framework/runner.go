@@ -0,0 +1,216 @@
+package framework
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Runner runs the code blocks of cases that use one mode, such as "test", in one language. A new
+// Runner is made for each document, so it may keep state that is shared by the document's cases.
+type Runner interface {
+	// Prepare is called before running a case
+	Prepare(c *CaseContext) error
+	// Run runs a case, returning an error if it fails
+	Run(c *CaseContext) error
+	// Cleanup is called after running a case, even if it failed
+	Cleanup(c *CaseContext) error
+}
+
+// CaseContext is everything a Runner is told about the case it runs
+type CaseContext struct {
+	// ID of the case, and the path of the document it is in
+	ID   string
+	Path string
+	// Mode of the case, and the language of its code block, after resolving aliases
+	Mode string
+	Lang string
+	// Source code of the code block
+	Source string
+	// Value of the fixture's field for the mode. Built-in modes have their own structures, such
+	// as *testDetails for "test", while modes registered from outside the framework get the
+	// field's value as deserialized from YAML.
+	Config interface{}
+	// Value of the fixture's field for the mode as deserialized from YAML, for every mode, so that
+	// Runners registered from outside the framework for a built-in mode can read it
+	Raw interface{}
+	// Name of the session the case is in, if any
+	Session string
+	// Cancelled when the case times out, Runners should stop as soon as they can
+	Context context.Context
+}
+
+// cancelGrace is how long a case that timed out is given to stop, before it is abandoned
+const cancelGrace = time.Second
+
+// NewRunnerFunc makes a Runner for a document
+type NewRunnerFunc func() Runner
+
+// AnyLang is the language to register a Runner with, for modes that don't depend upon the
+// language of the code block, such as "filltype". Runners registered for a specific language
+// take precedence.
+const AnyLang = "*"
+
+// runnerKey identifies a registered Runner
+type runnerKey struct {
+	mode string
+	lang string
+}
+
+// registeredRunners are the Runners known about, by mode and language
+var registeredRunners = map[runnerKey]NewRunnerFunc{}
+
+// builtinModes are the modes that are fields of docrunDetails
+var builtinModes = []string{"pass", "test", "command", "compile", "check", "filltype", "schema"}
+
+func init() {
+	RegisterRunner("test", "starlark", func() Runner {
+		return &starlarkPlugin{runner: NewStarlarkRunner()}
+	})
+	RegisterRunner("command", "shell", func() Runner {
+		return &commandLinePlugin{runner: NewCommandLineRunner()}
+	})
+	RegisterRunner("compile", "go", func() Runner {
+		return &goRunner{}
+	})
+	RegisterRunner("check", AnyLang, func() Runner {
+		return &syntaxPlugin{}
+	})
+	RegisterRunner("filltype", AnyLang, func() Runner {
+		return &filltypePlugin{}
+	})
+}
+
+// RegisterRunner adds a Runner for a mode and language, replacing any that was already
+// registered. A mode that isn't built in becomes a field that fixtures may use, whose value is
+// given to the Runner as CaseContext.Config.
+func RegisterRunner(mode, lang string, newRunner NewRunnerFunc) {
+	registeredRunners[runnerKey{mode: mode, lang: lang}] = newRunner
+}
+
+// customModes returns the sorted names of modes registered from outside the framework.
+func customModes() []string {
+	modes := []string{}
+	for key := range registeredRunners {
+		if !containsString(builtinModes, key.mode) && !containsString(modes, key.mode) {
+			modes = append(modes, key.mode)
+		}
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// takeCustomModes removes the fields for custom modes from the raw fields of a fixture, so that
+// they aren't reported as unknown, and returns them.
+func takeCustomModes(fields map[string]interface{}) map[string]interface{} {
+	taken := map[string]interface{}{}
+	switch docrun := fields["docrun"].(type) {
+	case map[interface{}]interface{}:
+		for _, mode := range customModes() {
+			if val, ok := docrun[mode]; ok {
+				taken[mode] = val
+				delete(docrun, mode)
+			}
+		}
+	case map[string]interface{}:
+		for _, mode := range customModes() {
+			if val, ok := docrun[mode]; ok {
+				taken[mode] = val
+				delete(docrun, mode)
+			}
+		}
+	}
+	return taken
+}
+
+// lookupRunner finds which registered Runner handles a mode and language.
+func lookupRunner(mode, lang string) (runnerKey, NewRunnerFunc, bool) {
+	key := runnerKey{mode: mode, lang: lang}
+	if newRunner, ok := registeredRunners[key]; ok {
+		return key, newRunner, true
+	}
+	key = runnerKey{mode: mode, lang: AnyLang}
+	newRunner, ok := registeredRunners[key]
+	return key, newRunner, ok
+}
+
+// runner returns the document's Runner for a mode and language, making it the first time.
+func (f *DocRunner) runner(mode, lang string) (Runner, error) {
+	key, newRunner, ok := lookupRunner(mode, lang)
+	if !ok {
+		return nil, fmt.Errorf("unknown code language %s", lang)
+	}
+	if f.runners == nil {
+		f.runners = map[runnerKey]Runner{}
+	}
+	r, ok := f.runners[key]
+	if !ok {
+		r = newRunner()
+		f.runners[key] = r
+	}
+	return r, nil
+}
+
+// DispatchCase runs a case with the Runner for its mode and language, returning an error if it
+// fails. A case that times out is cancelled, and its Runner isn't used again, so neither are any
+// of its sessions. Later cases in those sessions fail. A case that doesn't stop within
+// cancelGrace is abandoned, and may still interfere with capturing what later cases print, since
+// that swaps os.Stderr.
+func (f *DocRunner) DispatchCase(c *CaseContext) error {
+	if c.Session != "" && f.timedOut[c.Session] != "" {
+		return fmt.Errorf("session %s ended when case %s timed out", c.Session, f.timedOut[c.Session])
+	}
+	key, _, _ := lookupRunner(c.Mode, c.Lang)
+	r, err := f.runner(c.Mode, c.Lang)
+	if err != nil {
+		return err
+	}
+	if c.Session != "" && !containsString(f.runnerSessions[key], c.Session) {
+		if f.runnerSessions == nil {
+			f.runnerSessions = map[runnerKey][]string{}
+		}
+		f.runnerSessions[key] = append(f.runnerSessions[key], c.Session)
+	}
+	err = r.Prepare(c)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.Background(), func() {}
+	limit := f.timeout()
+	if limit != "" {
+		timeout, _ := time.ParseDuration(limit)
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+	defer cancel()
+	c.Context = ctx
+	done := make(chan error, 1)
+	go func() {
+		done <- r.Run(c)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		delete(f.runners, key)
+		if f.timedOut == nil {
+			f.timedOut = map[string]string{}
+		}
+		for _, session := range f.runnerSessions[key] {
+			f.timedOut[session] = c.ID
+		}
+		delete(f.runnerSessions, key)
+		// Wait for the case to stop, so that it doesn't run alongside later cases or write
+		// output after the results. One that doesn't stop in time is abandoned, uncleaned.
+		select {
+		case <-done:
+			r.Cleanup(c)
+		case <-time.After(cancelGrace):
+			log.Errorf("case %s: still running after it timed out", c.ID)
+		}
+		return fmt.Errorf("timed out after %s", limit)
+	}
+	if cleanupErr := r.Cleanup(c); err == nil {
+		err = cleanupErr
+	}
+	return err
+}
@@ -0,0 +1,90 @@
+package framework
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// echoRunner is a custom mode that checks a code block's text against the value of the fixture
+type echoRunner struct {
+	calls []string
+}
+
+func (r *echoRunner) Prepare(c *CaseContext) error {
+	r.calls = append(r.calls, "prepare "+c.ID)
+	return nil
+}
+
+func (r *echoRunner) Run(c *CaseContext) error {
+	r.calls = append(r.calls, "run "+c.ID)
+	config := toStringMap(c.Config)
+	if actual := strings.TrimSpace(c.Source); actual != config["expect"] {
+		return fmt.Errorf("echoed \"%s\", expected \"%v\"", actual, config["expect"])
+	}
+	return nil
+}
+
+func (r *echoRunner) Cleanup(c *CaseContext) error {
+	r.calls = append(r.calls, "cleanup "+c.ID)
+	return nil
+}
+
+func TestRegisterRunner(t *testing.T) {
+	echo := &echoRunner{}
+	RegisterRunner("echo", "text", func() Runner { return echo })
+	defer delete(registeredRunners, runnerKey{mode: "echo", lang: "text"})
+
+	runTestdata("testdata/custom_mode.md")
+	expect := []string{
+		`case custom-modes/2: echoed "hello", expected "goodbye"`,
+		`case custom-modes/3: unknown code language markdown`,
+	}
+	if actual := errorStrings(runner.Errs); !reflect.DeepEqual(actual, expect) {
+		t.Errorf("errors mismatch, actual: %v, expect: %v", actual, expect)
+	}
+	calls := []string{
+		"prepare custom-modes/1", "run custom-modes/1", "cleanup custom-modes/1",
+		"prepare custom-modes/2", "run custom-modes/2", "cleanup custom-modes/2",
+	}
+	if !reflect.DeepEqual(echo.calls, calls) {
+		t.Errorf("calls mismatch, actual: %v, expect: %v", echo.calls, calls)
+	}
+	if res := runner.GetResults(); res.CountSuccess != 1 {
+		t.Errorf("Expected 1 successful test, got %d", res.CountSuccess)
+	}
+}
+
+// rawRunner records the raw config of the cases it runs
+type rawRunner struct {
+	raw []interface{}
+}
+
+func (r *rawRunner) Prepare(c *CaseContext) error { return nil }
+
+func (r *rawRunner) Run(c *CaseContext) error {
+	r.raw = append(r.raw, c.Raw)
+	return nil
+}
+
+func (r *rawRunner) Cleanup(c *CaseContext) error { return nil }
+
+func TestRegisterRunnerBuiltinMode(t *testing.T) {
+	ruby := &rawRunner{}
+	RegisterRunner("test", "ruby", func() Runner { return ruby })
+	defer delete(registeredRunners, runnerKey{mode: "test", lang: "ruby"})
+
+	runTestdata("testdata/builtin_mode.md")
+	if runner.HasError() {
+		t.Fatalf("Docrunner encountered errors: %v", runner.Errs)
+	}
+	if len(ruby.raw) != 1 {
+		t.Fatalf("Expected 1 case to run, got %d", len(ruby.raw))
+	}
+	config := toStringMap(ruby.raw[0])
+	expect := map[string]interface{}{"call": "greet()", "actual": "stdout", "expect": "hello"}
+	if !reflect.DeepEqual(config, expect) {
+		t.Errorf("raw config mismatch, actual: %v, expect: %v", config, expect)
+	}
+}
@@ -0,0 +1,236 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/dataset"
+	dsvalidate "github.com/qri-io/dataset/validate"
+	"github.com/qri-io/jsonschema"
+	"github.com/qri-io/qri/base/fill"
+)
+
+// contentPath matches content-addressed paths, such as "/ipfs/QmHash" or "/map/QmHash/body.json"
+var contentPath = regexp.MustCompile(`^/[a-z]+/[^/]+(/.*)?$`)
+
+// validateFilled checks the meaning of a filled structure, beyond the names and types of its
+// fields. Each violation is reported at the path of the field at fault.
+func validateFilled(filltype string, value interface{}) error {
+	collector := fill.NewErrorCollector()
+	switch v := value.(type) {
+	case *dataset.Dataset:
+		validateDataset(v, collector)
+	case *dataset.Structure:
+		validateStructure(v, collector)
+	case *dataset.Commit:
+		validateCommit(v, collector)
+	case *dataset.Meta:
+		validatePath(v.Path, collector)
+	case *dataset.Transform:
+		validatePath(v.Path, collector)
+	case *dataset.Viz:
+		validatePath(v.Path, collector)
+	default:
+		return fmt.Errorf("filltype %s has no validation", filltype)
+	}
+	return collector.AsSingleError()
+}
+
+// validateDataset checks the paths and name of a dataset, along with each of its components.
+func validateDataset(ds *dataset.Dataset, collector *fill.ErrorCollector) {
+	validatePath(ds.Path, collector)
+	if ds.PreviousPath != "" {
+		collector.PushField("previousPath")
+		if !contentPath.MatchString(ds.PreviousPath) {
+			collector.Add(pathError(ds.PreviousPath))
+		}
+		collector.PopField()
+	}
+	if ds.Name != "" {
+		collector.PushField("name")
+		if err := dsvalidate.ValidName(ds.Name); err != nil {
+			collector.Add(fmt.Errorf("invalid name \"%s\", names must start with a letter and "+
+				"only contain letters, numbers and underscores", ds.Name))
+		}
+		collector.PopField()
+	}
+	if ds.Meta != nil {
+		collector.PushField("meta")
+		validatePath(ds.Meta.Path, collector)
+		collector.PopField()
+	}
+	if ds.Structure != nil {
+		collector.PushField("structure")
+		schema := validateStructure(ds.Structure, collector)
+		collector.PopField()
+		// An inline body must match the schema.
+		if schema != nil && ds.Body != nil {
+			collector.PushField("body")
+			addSchemaErrors(schema, ds.Body, collector)
+			collector.PopField()
+		}
+	}
+	if ds.Commit != nil {
+		collector.PushField("commit")
+		validateCommit(ds.Commit, collector)
+		collector.PopField()
+	}
+	if ds.Transform != nil {
+		collector.PushField("transform")
+		validatePath(ds.Transform.Path, collector)
+		collector.PopField()
+	}
+	if ds.Viz != nil {
+		collector.PushField("viz")
+		validatePath(ds.Viz.Path, collector)
+		collector.PopField()
+	}
+}
+
+// validateStructure checks that the format is known and that the schema is valid JSON Schema,
+// returning the schema if there is a valid one.
+func validateStructure(st *dataset.Structure, collector *fill.ErrorCollector) *jsonschema.RootSchema {
+	validatePath(st.Path, collector)
+	if st.Format != "" {
+		if _, err := dataset.ParseDataFormatString(st.Format); err != nil {
+			formats := []string{}
+			for _, df := range dataset.SupportedDataFormats() {
+				formats = append(formats, df.String())
+			}
+			collector.PushField("format")
+			collector.Add(fmt.Errorf("unknown format \"%s\", expected one of: %s", st.Format,
+				strings.Join(formats, ", ")))
+			collector.PopField()
+		}
+	}
+	if st.Schema == nil {
+		return nil
+	}
+	schema, err := parseSchema(st.Schema)
+	if err != nil {
+		collector.PushField("schema")
+		collector.Add(err)
+		collector.PopField()
+		return nil
+	}
+	return schema
+}
+
+// validateCommit checks the path and title of a commit.
+func validateCommit(cm *dataset.Commit, collector *fill.ErrorCollector) {
+	validatePath(cm.Path, collector)
+	if err := dsvalidate.Commit(cm); err != nil {
+		collector.PushField("title")
+		collector.Add(err)
+		collector.PopField()
+	}
+}
+
+// validatePath checks the "path" field of a component, if it is set.
+func validatePath(path string, collector *fill.ErrorCollector) {
+	if path == "" {
+		return
+	}
+	collector.PushField("path")
+	if !contentPath.MatchString(path) {
+		collector.Add(pathError(path))
+	}
+	collector.PopField()
+}
+
+// pathError is the error for a path that isn't content-addressed.
+func pathError(path string) error {
+	return fmt.Errorf("invalid path \"%s\", expected a content-addressed path like \"/ipfs/QmHash\"",
+		path)
+}
+
+// parseSchema converts a deserialized JSON Schema into one that can validate data.
+func parseSchema(schema interface{}) (*jsonschema.RootSchema, error) {
+	data, err := json.Marshal(jsonCompatible(schema))
+	if err != nil {
+		return nil, err
+	}
+	rs := &jsonschema.RootSchema{}
+	if err := json.Unmarshal(data, rs); err != nil {
+		return nil, fmt.Errorf("invalid JSON Schema: %s", err)
+	}
+	return rs, nil
+}
+
+// jsonCompatible converts maps decoded from YAML, which have interface{} keys, into maps with
+// string keys, so that they can be serialized as JSON.
+func jsonCompatible(data interface{}) interface{} {
+	switch v := data.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[fmt.Sprintf("%v", key)] = jsonCompatible(elem)
+		}
+		return m
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, elem := range v {
+			m[key] = jsonCompatible(elem)
+		}
+		return m
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, elem := range v {
+			list[i] = jsonCompatible(elem)
+		}
+		return list
+	default:
+		return data
+	}
+}
+
+// validateSchemaBytes validates JSON against a schema, returning violations in order of their
+// property path, so that they are reported consistently.
+func validateSchemaBytes(schema *jsonschema.RootSchema, data []byte) ([]jsonschema.ValError, error) {
+	errs, err := schema.ValidateBytes(data)
+	sort.SliceStable(errs, func(i, j int) bool {
+		return errs[i].PropertyPath < errs[j].PropertyPath
+	})
+	return errs, err
+}
+
+// schemaErrorMessage describes a violation of a schema, along with the invalid value.
+func schemaErrorMessage(valErr jsonschema.ValError) string {
+	if valErr.InvalidValue == nil {
+		return valErr.Message
+	}
+	return fmt.Sprintf("%s %s", jsonschema.InvalidValueString(valErr.InvalidValue), valErr.Message)
+}
+
+// addSchemaErrors validates data against a schema, adding an error for each violation at the
+// path of the invalid value.
+func addSchemaErrors(schema *jsonschema.RootSchema, data interface{}, collector *fill.ErrorCollector) {
+	bytes, err := json.Marshal(jsonCompatible(data))
+	if err != nil {
+		collector.Add(err)
+		return
+	}
+	errs, err := validateSchemaBytes(schema, bytes)
+	if err != nil {
+		collector.Add(err)
+		return
+	}
+	for _, valErr := range errs {
+		// Property paths are JSON pointers, such as "/0/1".
+		steps := strings.Split(strings.Trim(valErr.PropertyPath, "/"), "/")
+		for _, step := range steps {
+			if step != "" {
+				collector.PushField(step)
+			}
+		}
+		collector.Add(fmt.Errorf("%s", schemaErrorMessage(valErr)))
+		for _, step := range steps {
+			if step != "" {
+				collector.PopField()
+			}
+		}
+	}
+}
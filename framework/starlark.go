@@ -1,24 +1,18 @@
 package framework
 
 import (
-	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http/httptest"
+	"net/http"
 	"os"
 	"path/filepath"
-	"reflect"
 	"strings"
+	"sync"
 
 	golog "github.com/ipfs/go-log"
 	"github.com/qri-io/dataset"
 	"github.com/qri-io/qri/startf/context"
 	stards "github.com/qri-io/qri/startf/ds"
-	starhtml "github.com/qri-io/starlib/html"
 	starhttp "github.com/qri-io/starlib/http"
-	startime "github.com/qri-io/starlib/time"
-	starutil "github.com/qri-io/starlib/util"
-	starxlsx "github.com/qri-io/starlib/xlsx"
 	"go.starlark.net/starlark"
 	"go.starlark.net/starlarkstruct"
 )
@@ -27,139 +21,206 @@ var log = golog.Logger("docrun")
 
 // StarlarkRunner collects methods for running starlark code
 type StarlarkRunner struct {
+	sessions map[string]*starlarkSession
+}
+
+// starlarkSession is state shared by each code block in a document that uses the same session.
+// Later blocks can see definitions made by earlier ones.
+type starlarkSession struct {
+	globals starlark.StringDict
+	ds      *stards.Dataset
+	ctx     *context.Context
+	// Values returned by ctx.get_config and ctx.get_secret
+	config  map[string]interface{}
+	secrets map[string]interface{}
+	// Answers http requests with the WebProxy of the case being run
+	transport *caseTransport
 }
 
 // NewStarlarkRunner returns a new StarlarkRunner
 func NewStarlarkRunner() *StarlarkRunner {
-	return &StarlarkRunner{}
+	return &StarlarkRunner{sessions: map[string]*starlarkSession{}}
 }
 
-// ModuleLoader can load starlark modules (like http)
-type ModuleLoader func(thread *starlark.Thread, module string) (starlark.StringDict, error)
+// starlarkPlugin runs test cases written in starlark, expecting CaseContext.Config to be
+// *testDetails. Since a plugin is made for each document, sessions last for the whole document.
+type starlarkPlugin struct {
+	runner *StarlarkRunner
+}
 
-// NewMockModuleLoader returns a ModuleLoader to load mock modules
-func NewMockModuleLoader(proxy *proxyDetails) ModuleLoader {
-	return func(thread *starlark.Thread, module string) (dict starlark.StringDict, err error) {
-		if module == "http.star" {
-			m := &MockHTTPModule{proxy: proxy}
-			return starlark.StringDict{
-				"http": m.Struct(),
-			}, nil
-		} else if module == "qri.star" {
-			m := &MockQriModule{}
-			return starlark.StringDict{
-				"qri": m.Struct(),
-			}, nil
-		} else if module == "html.star" {
-			return starhtml.LoadModule()
-		} else if module == "time.star" {
-			return startime.LoadModule()
-		} else if module == "xlsx.star" {
-			return starxlsx.LoadModule()
-		} else {
-			return nil, fmt.Errorf("module not defined: \"%s\"", module)
-		}
-	}
+// Prepare does nothing, sessions are created as they're needed
+func (p *starlarkPlugin) Prepare(c *CaseContext) error {
+	return nil
 }
 
-// MockHTTPModule is a module for mocking out http functionality.
-type MockHTTPModule struct {
-	proxy *proxyDetails
+// Run runs the test case in its session
+func (p *starlarkPlugin) Run(c *CaseContext) error {
+	var cancelled <-chan struct{}
+	if c.Context != nil {
+		cancelled = c.Context.Done()
+	}
+	return p.runner.runInSession(cancelled, c.Session, c.Config.(*testDetails), c.Source)
 }
 
-// Struct returns a starlark struct with methods
-func (m *MockHTTPModule) Struct() *starlarkstruct.Struct {
-	return starlarkstruct.FromStringDict(starlarkstruct.Default, m.StringDict())
+// Cleanup does nothing, sessions are kept for later code blocks
+func (p *starlarkPlugin) Cleanup(c *CaseContext) error {
+	return nil
 }
 
-// StringDict returns the module as a dictionary keyed by strings
-func (m *MockHTTPModule) StringDict() starlark.StringDict {
-	return starlark.StringDict{
-		"get": starlark.NewBuiltin("get", m.get),
-	}
+// newStarlarkSession returns a session with `ds` and `ctx` ready to use.
+func newStarlarkSession() *starlarkSession {
+	ds := &stards.Dataset{}
+	ds.SetMutable(&dataset.Dataset{})
+	config := map[string]interface{}{}
+	secrets := map[string]interface{}{}
+	ctx := context.NewContext(config, secrets)
+	return &starlarkSession{globals: starlark.StringDict{}, ds: ds, ctx: ctx, config: config,
+		secrets: secrets, transport: &caseTransport{}}
 }
 
-// get performs a mock http request
-func (m *MockHTTPModule) get(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	if m.proxy != nil {
-		// TODO: Check get's argument against m.proxy.URL
-		result, err := starutil.Marshal(m.proxy.Response)
-		if err != nil {
-			return starlark.None, err
-		}
-		// Construct a fake http response.
-		rec := httptest.NewRecorder()
-		rec.WriteString(result.String())
-		res := rec.Result()
-		r := &starhttp.Response{*res}
-		// Attack the request, convert to starlark struct type.
-		r.Request = httptest.NewRequest("GET", m.proxy.URL, nil)
-		return r.Struct(), nil
+// session returns the named session, creating it if needed. An unnamed session is never shared.
+func (r *StarlarkRunner) session(name string) *starlarkSession {
+	if name == "" {
+		return newStarlarkSession()
 	}
-	return starlark.None, fmt.Errorf("Cannot use http.get without WebProxy")
+	if _, ok := r.sessions[name]; !ok {
+		r.sessions[name] = newStarlarkSession()
+	}
+	return r.sessions[name]
 }
 
-// MockQriModule is a module for mocking out qri functionality.
-type MockQriModule struct {
+// ModuleLoader can load starlark modules (like http)
+type ModuleLoader func(thread *starlark.Thread, module string) (starlark.StringDict, error)
+
+// MockHTTPModule is the starlib http module, with requests sent to a local server that answers
+// using the routes of a WebProxy, so examples run offline and exercise the real client. When a
+// cassette is being recorded, requests go to the network.
+type MockHTTPModule struct {
+	transport http.RoundTripper
 }
 
+// httpClientLock guards starhttp.Client while it's replaced to load the http module
+var httpClientLock sync.Mutex
+
 // Struct returns a starlark struct with methods
-func (m *MockQriModule) Struct() *starlarkstruct.Struct {
-	return starlarkstruct.FromStringDict(starlarkstruct.Default, m.StringDict())
+func (m *MockHTTPModule) Struct() *starlarkstruct.Struct {
+	// The module keeps whichever client is set when it's loaded. A case abandoned after timing
+	// out may still be loading it.
+	httpClientLock.Lock()
+	defer httpClientLock.Unlock()
+	client := starhttp.Client
+	starhttp.Client = &http.Client{Transport: m.transport}
+	defer func() { starhttp.Client = client }()
+	module, _ := starhttp.LoadModule()
+	return module["http"].(*starlarkstruct.Struct)
 }
 
 // StringDict returns the module as a dictionary keyed by strings
-func (m *MockQriModule) StringDict() starlark.StringDict {
-	return starlark.StringDict{
-		"list_datasets": starlark.NewBuiltin("list_datasets", m.listDatasets),
-	}
+func (m *MockHTTPModule) StringDict() starlark.StringDict {
+	dict := starlark.StringDict{}
+	m.Struct().ToStringDict(dict)
+	return dict
 }
 
-// ListDatasets creates a list of mock datasetrefs
-func (m *MockQriModule) listDatasets(thread *starlark.Thread, _ *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
-	ls := &starlark.List{}
-	ls.Append(starlark.String("test/ds_1@QmExample/ipfs/QmExample"))
-	ls.Append(starlark.String("test/ds_2@QmSample/ipfs/QmSample"))
-	return ls, nil
+// captureOutput runs fn with stderr, where starlark prints, written to the file at path. Stderr
+// is restored afterwards, even if fn panics.
+func captureOutput(path string, fn func() error) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("capturing output: %s", err)
+	}
+	stderr := os.Stderr
+	os.Stderr = file
+	defer func() {
+		os.Stderr = stderr
+		file.Close()
+	}()
+	return fn()
 }
 
 // Run runs the actual starlark code from a test case.
 func (r *StarlarkRunner) Run(details *testDetails, sourceCode string) error {
+	return r.RunInSession("", details, sourceCode)
+}
+
+// RunInSession runs the starlark code from a test case, sharing global definitions, `ds` and
+// `ctx` with other code blocks in the same session.
+func (r *StarlarkRunner) RunInSession(name string, details *testDetails, sourceCode string) error {
+	return r.runInSession(nil, name, details, sourceCode)
+}
+
+// runInSession runs the starlark code from a test case, stopping it once cancelled is closed.
+func (r *StarlarkRunner) runInSession(cancelled <-chan struct{}, name string, details *testDetails, sourceCode string) (err error) {
 	// Log information about the test before running it (debug level only).
 	log.Debugf("==============================")
+	log.Debugf("Session: %s", name)
 	log.Debugf("WebProxy: %p", details.WebProxy)
 	log.Debugf("Setup:  %s", details.Setup)
 	log.Debugf("Call:   %s", details.Call)
 	log.Debugf("Actual: %s", details.Actual)
 	log.Debugf("Expect: %s", details.Expect)
+	log.Debugf("Asserts: %d", len(details.Asserts))
+	log.Debugf("Teardown: %s", details.Teardown)
 	log.Debugf("code: {%s}", sourceCode)
 	log.Debugf("------------------------------")
 
-	var err error
+	if details.WebProxy != nil {
+		// The proxy's server only lasts as long as the test case.
+		defer details.WebProxy.close()
+	}
+
+	useTransformDialect()
+	session := r.session(name)
+	// Modules loaded by earlier code blocks in the session use this case's WebProxy.
+	session.transport.details = details
 	thread := &starlark.Thread{
-		Load: NewMockModuleLoader(details.WebProxy),
+		Load: newModuleLoader(details, session.transport),
 	}
-	// Environment has `ds` and `ctx` predefined.
+	if cancelled != nil {
+		finished := make(chan struct{})
+		defer close(finished)
+		go func() {
+			select {
+			case <-cancelled:
+				thread.Cancel("timed out")
+			case <-finished:
+			}
+		}()
+	}
+	qri := &MockQriModule{datasets: details.datasets}
+	ds := session.ds
+	ctx := session.ctx
+	session.setContextValues(details)
+	// Environment has the predeclared names, and definitions from earlier code blocks in the session.
 	environment := make(map[string]starlark.Value)
-	ds := stards.Dataset{}
-	ds.SetMutable(&dataset.Dataset{})
-	ctx := context.NewContext(make(map[string]interface{}), make(map[string]interface{}))
+	for k, v := range session.globals {
+		environment[k] = v
+	}
+	// Like a qri transform, load_dataset and error are available without loading a module.
+	environment["load_dataset"] = qri.StringDict()["load_dataset"]
+	environment["error"] = starlark.NewBuiltin("error", starlarkError)
 
 	environment["ds"] = ds.Methods()
 	environment["ctx"] = ctx.Struct()
-	// Setup is either modifying a field of context (handled specially), or mutates an existing
+	// Teardown runs last, even if the test or its Setup fails, to undo changes to the session.
+	if details.Teardown != "" {
+		defer func() {
+			log.Info("running Teardown...")
+			environment["ds"] = ds.Methods()
+			environment["ctx"] = ctx.Struct()
+			_, teardownErr := starlark.ExecFile(thread, "", details.Teardown, environment)
+			if teardownErr != nil && err == nil {
+				err = fmt.Errorf("during Teardown: %s", teardownErr.Error())
+			}
+		}()
+	}
+	// Setup is either assigning ctx.download (handled specially), or mutates an existing
 	// variable (such as calling set_body on ds). It won't modify the top-level environment.
+	setupDownloaded := false
 	if details.Setup != "" {
 		log.Info("running Setup...")
-		if strings.HasPrefix(details.Setup, "ctx.download = ") {
-			// TODO: Actual implementation
-			result := starlark.NewList([]starlark.Value{starlark.Value(starlark.String("test"))})
-			ctx.SetResult("download", result)
-		} else {
-			_, err = starlark.ExecFile(thread, "", details.Setup, environment)
-			if err != nil {
-				return fmt.Errorf("during Setup: %s", err.Error())
-			}
+		if setupDownloaded, err = runSetup(thread, details.Setup, ctx, environment); err != nil {
+			return fmt.Errorf("during Setup: %s", err.Error())
 		}
 	}
 
@@ -167,80 +228,101 @@ func (r *StarlarkRunner) Run(details *testDetails, sourceCode string) error {
 	environment["ctx"] = ctx.Struct()
 	// Run sourceCode
 	log.Info("running code block...")
-	environment, err = starlark.ExecFile(thread, "", sourceCode, environment)
+	globals, err := starlark.ExecFile(thread, "", sourceCode, environment)
 	if err != nil {
 		return fmt.Errorf("running code block: %s", err.Error())
 	}
+	// Definitions from the code block are visible to later steps, and later code blocks.
+	for k, v := range globals {
+		environment[k] = v
+		session.globals[k] = v
+	}
+	downloaded, err := setDownload(thread, details, ctx, environment)
+	if err != nil {
+		return err
+	}
+	downloaded = downloaded || setupDownloaded
 
-	// Preserve stdout so it can be captured
+	// What the code prints is captured here
 	stdoutTempFile := filepath.Join(os.TempDir(), "stdout")
-	captureWrite, _ := os.Create(stdoutTempFile)
-	preserveOut := os.Stdout
 
-	environment["ds"] = ds.Methods()
-	environment["ctx"] = ctx.Struct()
-	// Call is the entry point to run in order to exercise the test case.
-	// TODO(dlong): Validate that this is a single function
-	log.Info("running Call...")
-	// Capture stdout when the main part is executed
-	// TODO: `print` statement in starlark is writing to stderr, not stdout. Fix this, please.
-	os.Stderr = captureWrite
-	environment, err = starlark.ExecFile(thread, "", "result = "+details.Call, environment)
-	captureWrite.Close()
-	os.Stderr = preserveOut
-	if err != nil {
-		return fmt.Errorf("during Call: %s", err.Error())
-	}
+	if details.Lifecycle {
+		log.Info("running lifecycle...")
+		err = captureOutput(stdoutTempFile, func() error {
+			return runLifecycle(thread, ds, ctx, environment, downloaded)
+		})
+		if err != nil {
+			return err
+		}
+	} else if details.Call == "" {
+		// Without a Call, the test only checks that the code block runs.
+		log.Info("blank Call, nothing to do")
+		log.Info("success!")
+		return nil
+	} else {
+		environment["ds"] = ds.Methods()
+		environment["ctx"] = ctx.Struct()
+		// Call is the entry point to run in order to exercise the test case.
+		// TODO(dlong): Validate that this is a single function
+		log.Info("running Call...")
+		// Capture stdout when the main part is executed
+		// TODO: `print` statement in starlark is writing to stderr, not stdout. Fix this, please.
+		var called starlark.Value
+		err = captureOutput(stdoutTempFile, func() (err error) {
+			if called, err = evalStarlark(thread, details.Call, environment); err != nil {
+				return fmt.Errorf("during Call: %s", err.Error())
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
 
-	// Assign special function results to ctx field
-	if strings.HasPrefix(details.Call, "download") {
-		ctx.SetResult("download", environment["result"])
+		// Assign special function results to ctx field, as the lifecycle does
+		switch calledFunction(details.Call) {
+		case "download":
+			ctx.SetResult("download", called)
+		case "transform":
+			// More of a lint rule: transform should not return anything.
+			if called != starlark.None {
+				return fmt.Errorf("transform should not return anything")
+			}
+		}
 	}
-	// More of a lint rule: transform should not return anything.
-	if strings.HasPrefix(details.Call, "transform") {
-		transformResult := environment["result"]
-		if transformResult != starlark.None {
-			return fmt.Errorf("transform should not return anything")
+
+	if details.ExpectDataset != nil {
+		log.Info("comparing dataset...")
+		if err := compareDataset(thread, ds, details.ExpectDataset); err != nil {
+			return err
 		}
 	}
 
 	// Actual accesses the results of the test case.
-	log.Info("running Actual...")
-	var actual interface{}
-
-	if details.Actual == "" {
+	if details.Actual == "" && len(details.Asserts) == 0 {
 		log.Info("blank Actual, nothing to do")
 		log.Info("success!")
 		return nil
-	} else if details.Actual == "stdout.get()" {
-		// Get what was written to stdout.
-		stdoutText, _ := ioutil.ReadFile(stdoutTempFile)
-		actual = strings.TrimSpace(string(stdoutText))
-	} else {
+	}
+	if details.Actual != "" {
+		log.Info("running Actual...")
 		environment["ds"] = ds.Methods()
 		environment["ctx"] = ctx.Struct()
-		// TODO(dlong): Validate that this is an expression (should not have side-effects)
-		environment, err = starlark.ExecFile(thread, "", "result = "+details.Actual, environment)
-		if err != nil {
-			return fmt.Errorf("during Actual: %s", err.Error())
+		if err := checkAssert(thread, details.Actual, details.Expect, environment, stdoutTempFile); err != nil {
+			return err
 		}
-		// Parse the results from Actual into a native data structure.
-		resultStr := environment["result"].String()
-		err = json.Unmarshal([]byte(resultStr), &actual)
-		if err != nil {
-			return fmt.Errorf("parsing \"%s\": %s", resultStr, err.Error())
+	}
+	// Every assert is checked, so that all of their failures are reported together.
+	failures := []string{}
+	for i, assert := range details.Asserts {
+		log.Infof("running asserts.%d...", i)
+		environment["ds"] = ds.Methods()
+		environment["ctx"] = ctx.Struct()
+		if err := checkAssert(thread, assert.Actual, assert.Expect, environment, stdoutTempFile); err != nil {
+			failures = append(failures, fmt.Sprintf("asserts.%d: %s", i, err))
 		}
 	}
-
-	actualString := fmt.Sprintf("%s", actual)
-	expectString := fmt.Sprintf("%s", details.Expect)
-
-	// Compare actual results against the expected results, fail if different.
-	if !reflect.DeepEqual(actualString, expectString) {
-		tmpl := `test case failure
-  actual: %s
-  expect: %s`
-		return fmt.Errorf(tmpl, actualString, expectString)
+	if len(failures) > 0 {
+		return fmt.Errorf("%s", strings.Join(failures, "\n"))
 	}
 
 	log.Info("success!")
@@ -0,0 +1,291 @@
+package framework
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/scanner"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"go.starlark.net/resolve"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+	"gopkg.in/yaml.v2"
+)
+
+// starlarkPredeclared are the names StarlarkRunner defines before running a code block.
+var starlarkPredeclared = []string{"ds", "ctx", "load_dataset", "error"}
+
+// checkKinds are the allowed values for docrunDetails.Check
+var checkKinds = []string{"syntax"}
+
+// checkCase is the CaseContext.Config for check cases
+type checkCase struct {
+	// Kind of check, such as "syntax"
+	Kind string
+	// Packages that a go fragment imports, given by the compile field
+	Imports []string
+}
+
+// syntaxPlugin checks the syntax of code blocks in any language that checkSyntax supports. It
+// expects CaseContext.Config to be *checkCase.
+type syntaxPlugin struct{}
+
+// Prepare does nothing, checks have no state
+func (p *syntaxPlugin) Prepare(c *CaseContext) error {
+	return nil
+}
+
+// Run checks the syntax of the code block
+func (p *syntaxPlugin) Run(c *CaseContext) error {
+	details := c.Config.(*checkCase)
+	return checkSyntax(c.Lang, c.Source, filepath.Dir(c.Path), details.Imports)
+}
+
+// Cleanup does nothing, checks have no state
+func (p *syntaxPlugin) Cleanup(c *CaseContext) error {
+	return nil
+}
+
+// checkSyntax parses source code, without running it, to make sure it is valid for its language.
+// Go code is type checked, with imports found from the module containing dir.
+func checkSyntax(lang, source, dir string, imports []string) error {
+	switch lang {
+	case "json":
+		var data interface{}
+		return json.Unmarshal([]byte(source), &data)
+	case "yaml":
+		var data interface{}
+		return yaml.Unmarshal([]byte(source), &data)
+	case "toml":
+		var data interface{}
+		_, err := toml.Decode(source, &data)
+		return err
+	case "csv":
+		_, err := csv.NewReader(strings.NewReader(source)).ReadAll()
+		return err
+	case "starlark":
+		return checkStarlark(source)
+	case "go":
+		return checkGo(source, dir, imports)
+	default:
+		return fmt.Errorf("no syntax check for language %s", lang)
+	}
+}
+
+// checkStarlark parses starlark code, and resolves the names it uses, which catches undefined
+// names without running the code.
+func checkStarlark(source string) error {
+	useTransformDialect()
+	file, err := syntax.Parse("", source, 0)
+	if err != nil {
+		return err
+	}
+	isPredeclared := func(name string) bool {
+		return containsString(starlarkPredeclared, name)
+	}
+	return resolve.File(file, isPredeclared, starlark.Universe.Has)
+}
+
+// fragmentImports are the standard library packages that Go fragments can use without importing
+// them, by name, when their path isn't the same as their name
+var fragmentImports = map[string]string{
+	"base64":   "encoding/base64",
+	"csv":      "encoding/csv",
+	"filepath": "path/filepath",
+	"http":     "net/http",
+	"ioutil":   "io/ioutil",
+	"json":     "encoding/json",
+	"rand":     "math/rand",
+	"url":      "net/url",
+	"utf8":     "unicode/utf8",
+}
+
+// checkGo type checks a go file, importing packages as they're built in the module containing
+// dir, so that those in the document's module are checked against their current source. A
+// fragment without a package clause is wrapped in a function, the same as the "func" compile
+// template, importing the given packages and the standard library packages it uses. Unused
+// variables and imports aren't errors in a fragment.
+func checkGo(source, dir string, imports []string) error {
+	root := findModuleRoot(dir)
+	if root == "" {
+		root = dir
+	}
+	if strings.HasPrefix(strings.TrimSpace(source), "package ") {
+		fset := token.NewFileSet()
+		file, err := parser.ParseFile(fset, "", source, 0)
+		if err != nil {
+			return err
+		}
+		imp, err := moduleImporter(fset, root, file)
+		if err != nil {
+			return err
+		}
+		conf := types.Config{Importer: imp}
+		_, err = conf.Check(file.Name.Name, fset, []*ast.File{file}, nil)
+		return err
+	}
+
+	fset := token.NewFileSet()
+	program, err := goProgram(&compileDetails{Template: "func", Imports: imports}, source)
+	if err != nil {
+		return err
+	}
+	file, err := parser.ParseFile(fset, "", program, 0)
+	if err != nil {
+		return fragmentError(err, program, source)
+	}
+	imports = append([]string{}, imports...)
+	for _, ident := range file.Unresolved {
+		if !isQualifier(file, ident) {
+			continue
+		}
+		path, ok := fragmentImports[ident.Name]
+		if !ok {
+			// Other packages must be imported, unless they're in the standard library.
+			pkg, err := build.Default.Import(ident.Name, "", build.FindOnly)
+			if err != nil || !pkg.Goroot {
+				continue
+			}
+			path = ident.Name
+		}
+		if !containsString(imports, path) {
+			imports = append(imports, path)
+		}
+	}
+	program, err = goProgram(&compileDetails{Template: "func", Imports: imports}, source)
+	if err != nil {
+		return err
+	}
+	fset = token.NewFileSet()
+	file, err = parser.ParseFile(fset, "", program, 0)
+	if err != nil {
+		return fragmentError(err, program, source)
+	}
+	imp, err := moduleImporter(fset, root, file)
+	if err != nil {
+		return err
+	}
+	var firstErr error
+	conf := types.Config{
+		Importer: imp,
+		Error: func(err error) {
+			if typeErr, ok := err.(types.Error); ok && typeErr.Soft {
+				return
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+		},
+	}
+	conf.Check(file.Name.Name, fset, []*ast.File{file}, nil)
+	if firstErr != nil {
+		return fragmentError(firstErr, program, source)
+	}
+	return nil
+}
+
+// listedPackage is the part of the output of `go list -json` used to import a package
+type listedPackage struct {
+	ImportPath string
+	// File holding the package's export data, in the build cache
+	Export string
+	Error  *struct {
+		Err string
+	}
+}
+
+// moduleImporter imports the packages a file uses from the export data that `go list` builds for
+// them, in the module at root.
+func moduleImporter(fset *token.FileSet, root string, file *ast.File) (types.Importer, error) {
+	packages := map[string]*listedPackage{}
+	args := []string{"list", "-e", "-export", "-deps", "-json"}
+	for _, spec := range file.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, path)
+	}
+	if len(args) > 5 {
+		list := exec.Command("go", args...)
+		list.Dir = root
+		out, err := list.Output()
+		if err != nil {
+			if exitErr, ok := err.(*exec.ExitError); ok {
+				return nil, fmt.Errorf("listing imports: %s", strings.TrimSpace(string(exitErr.Stderr)))
+			}
+			return nil, err
+		}
+		decoder := json.NewDecoder(bytes.NewReader(out))
+		for decoder.More() {
+			pkg := &listedPackage{}
+			if err := decoder.Decode(pkg); err != nil {
+				return nil, err
+			}
+			packages[pkg.ImportPath] = pkg
+		}
+	}
+	lookup := func(path string) (io.ReadCloser, error) {
+		pkg, ok := packages[path]
+		if !ok {
+			return nil, fmt.Errorf("package %s wasn't listed", path)
+		}
+		if pkg.Error != nil {
+			return nil, fmt.Errorf("%s", pkg.Error.Err)
+		}
+		return os.Open(pkg.Export)
+	}
+	return importer.ForCompiler(fset, "gc", lookup), nil
+}
+
+// isQualifier returns whether an identifier is used as the package of a selector, like fmt in
+// fmt.Println.
+func isQualifier(file *ast.File, ident *ast.Ident) bool {
+	found := false
+	ast.Inspect(file, func(node ast.Node) bool {
+		if sel, ok := node.(*ast.SelectorExpr); ok && sel.X == ident {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+// fragmentError reports an error in a wrapped fragment at its line within the fragment.
+func fragmentError(err error, program, source string) error {
+	offset := strings.Count(program[:strings.Index(program, source)], "\n")
+	var pos token.Position
+	var msg string
+	switch e := err.(type) {
+	case types.Error:
+		pos, msg = e.Fset.Position(e.Pos), e.Msg
+	case scanner.ErrorList:
+		if len(e) == 0 {
+			return err
+		}
+		pos, msg = e[0].Pos, e[0].Msg
+	default:
+		return err
+	}
+	// Errors in the wrapper, such as an import that can't be found, have no position in the
+	// fragment.
+	line := pos.Line - offset
+	if line < 1 || line > strings.Count(source, "\n")+1 {
+		return fmt.Errorf("%s", msg)
+	}
+	return fmt.Errorf("%d:%d: %s", line, pos.Column, msg)
+}
@@ -0,0 +1,262 @@
+package framework
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+)
+
+// checkFields walks raw fields, such as those of a fixture, comparing them to the fields of a
+// struct type. Unknown fields are reported along with the closest known field name, which is
+// much more helpful than the error fill.Struct gives when there's a typo.
+func checkFields(fields map[string]interface{}, t reflect.Type) error {
+	collector := fill.NewErrorCollector()
+	checkFieldsOfType(fields, t, collector)
+	return collector.AsSingleError()
+}
+
+// checkFieldsOfType compares the keys of a map against the fields of a struct type, recursing
+// into sub-structures.
+func checkFieldsOfType(fields map[string]interface{}, t reflect.Type, collector *fill.ErrorCollector) {
+	known := knownFieldTypes(t)
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fieldType, ok := known[strings.ToLower(k)]
+		if !ok {
+			names := make([]string, 0, len(known))
+			for name := range known {
+				names = append(names, name)
+			}
+			if suggest := closestName(strings.ToLower(k), names); suggest != "" {
+				collector.Add(fmt.Errorf("unknown field \"%s\", did you mean \"%s\"?", k, suggest))
+			} else {
+				collector.Add(fmt.Errorf("unknown field \"%s\", expected one of: %s", k,
+					strings.Join(sortedNames(names), ", ")))
+			}
+			continue
+		}
+		collector.PushField(k)
+		checkValueOfType(fields[k], fieldType, collector)
+		collector.PopField()
+	}
+}
+
+// checkValueOfType recurses into a value if its destination is a structure, or list of them.
+// Anything else (scalars, maps, interface{}) is left for fill.Struct to handle.
+func checkValueOfType(val interface{}, t reflect.Type, collector *fill.ErrorCollector) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		if m := toStringMap(val); m != nil {
+			checkFieldsOfType(m, t, collector)
+		}
+	case reflect.Slice:
+		if list, ok := val.([]interface{}); ok {
+			for i, elem := range list {
+				collector.PushField(fmt.Sprintf("%d", i))
+				checkValueOfType(elem, t.Elem(), collector)
+				collector.PopField()
+			}
+		}
+	}
+}
+
+// knownFieldTypes returns the lowercase names of fields in a struct type, using the same naming
+// rules as fill.Struct, mapped to the type of that field.
+func knownFieldTypes(t reflect.Type) map[string]reflect.Type {
+	known := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		// Unexported fields can't be filled.
+		if field.PkgPath != "" {
+			continue
+		}
+		name := strings.ToLower(field.Name)
+		if tag := field.Tag.Get("json"); tag != "" {
+			if pos := strings.Index(tag, ","); pos != -1 {
+				tag = tag[:pos]
+			}
+			name = strings.ToLower(tag)
+		}
+		known[name] = field.Type
+	}
+	return known
+}
+
+// toStringMap converts a deserialized map to have string keys. YAML produces maps with keys of
+// type interface{}, while JSON produces string keys.
+func toStringMap(obj interface{}) map[string]interface{} {
+	if m, ok := obj.(map[string]interface{}); ok {
+		return m
+	}
+	imap, ok := obj.(map[interface{}]interface{})
+	if !ok {
+		return nil
+	}
+	build := make(map[string]interface{})
+	for k, v := range imap {
+		build[fmt.Sprintf("%v", k)] = v
+	}
+	return build
+}
+
+// closestName finds the known name that is most similar to the given one, or returns the empty
+// string if none are similar enough to be a likely typo.
+func closestName(name string, known []string) string {
+	best := ""
+	bestDist := len(name)/3 + 2
+	for _, candidate := range sortedNames(known) {
+		dist := editDistance(name, candidate)
+		if dist < bestDist {
+			best = candidate
+			bestDist = dist
+		}
+	}
+	return best
+}
+
+// sortedNames returns a sorted copy of a list of names.
+func sortedNames(names []string) []string {
+	result := append([]string{}, names...)
+	sort.Strings(result)
+	return result
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(minInt(prev[j]+1, curr[j-1]+1), prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// checkModes makes sure that at most one mode is used by a fixture, and that each mode has the
+// fields it requires.
+func (d *docrunDetails) checkModes() error {
+	modes := []string{}
+	if d.Pass {
+		modes = append(modes, "pass")
+	}
+	if d.Test != nil {
+		modes = append(modes, "test")
+	}
+	if d.Command != nil {
+		modes = append(modes, "command")
+	}
+	// A check can use the imports of compile, without compiling.
+	if d.Compile != nil && d.Check == "" {
+		modes = append(modes, "compile")
+	}
+	if d.Check != "" {
+		modes = append(modes, "check")
+	}
+	if d.Filltype != "" {
+		modes = append(modes, "filltype")
+	}
+	if d.Schema != "" {
+		modes = append(modes, "schema")
+	}
+	for _, mode := range customModes() {
+		if _, ok := d.custom[mode]; ok {
+			modes = append(modes, mode)
+		}
+	}
+	if len(modes) > 1 {
+		return fmt.Errorf("fields \"%s\" are mutually exclusive, only one may be used",
+			strings.Join(modes, "\", \""))
+	}
+	if d.Skip != "" && d.Xfail != "" {
+		return fmt.Errorf("fields \"skip\", \"xfail\" are mutually exclusive, only one may be used")
+	}
+	if d.Session != "" && len(modes) == 1 && d.Test == nil {
+		return fmt.Errorf("field \"session\" can only be used with \"test\", not \"%s\"", modes[0])
+	}
+	if d.Check != "" && !containsString(checkKinds, d.Check) {
+		return fmt.Errorf("unknown check \"%s\", expected one of: %s", d.Check,
+			strings.Join(checkKinds, ", "))
+	}
+	if d.Validate && (d.Filltype == "" || strings.HasPrefix(d.Filltype, schemaPrefix)) {
+		return fmt.Errorf("field \"validate\" can only be used with a \"filltype\" structure")
+	}
+	if d.Roundtrip && (d.Filltype == "" || d.Filltype == "json" ||
+		strings.HasPrefix(d.Filltype, schemaPrefix)) {
+		return fmt.Errorf("field \"roundtrip\" can only be used with a \"filltype\" structure")
+	}
+	if d.Test != nil {
+		return d.Test.checkRequired()
+	}
+	if d.Compile != nil && d.Check != "" {
+		if d.Compile.Template != "" || d.Compile.Run || d.Compile.Expect != nil {
+			return fmt.Errorf("at compile: only \"imports\" can be used with \"check\"")
+		}
+		return nil
+	}
+	if d.Compile != nil {
+		return d.Compile.checkRequired()
+	}
+	return nil
+}
+
+// checkRequired makes sure a test has the fields it needs to be run.
+func (t *testDetails) checkRequired() error {
+	collector := fill.NewErrorCollector()
+	collector.PushField("test")
+	if t.Call == "" && !t.Lifecycle {
+		collector.Add(fmt.Errorf("field \"call\" or \"lifecycle\" is required"))
+	}
+	if t.Call != "" && t.Lifecycle {
+		collector.Add(fmt.Errorf("fields \"call\", \"lifecycle\" are mutually exclusive, only one may be used"))
+	}
+	if t.Download != "" && t.DownloadFile != "" {
+		collector.Add(fmt.Errorf("fields \"download\", \"download_file\" are mutually exclusive, only one may be used"))
+	}
+	if t.Actual != "" && !t.hasExpect {
+		collector.Add(fmt.Errorf("field \"expect\" is required when \"actual\" is set"))
+	}
+	for i, assert := range t.Asserts {
+		collector.PushField(fmt.Sprintf("asserts.%d", i))
+		assert.checkRequired(collector)
+		collector.PopField()
+	}
+	if t.ExpectDataset != nil {
+		collector.PushField("expect_dataset")
+		checkExpectDataset(t.ExpectDataset, collector)
+		collector.PopField()
+	}
+	if t.WebProxy != nil {
+		collector.PushField("webproxy")
+		t.WebProxy.checkRequired(collector)
+		collector.PopField()
+	}
+	collector.PopField()
+	return collector.AsSingleError()
+}
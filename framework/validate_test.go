@@ -0,0 +1,106 @@
+package framework
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCheckFixtureFields(t *testing.T) {
+	cases := []struct {
+		fields map[string]interface{}
+		expect string
+	}{
+		{
+			map[string]interface{}{
+				"docrun": map[interface{}]interface{}{"pass": true},
+			},
+			"",
+		},
+		{
+			map[string]interface{}{
+				"docrun": map[interface{}]interface{}{"Filltype": "json", "Lang": "json"},
+			},
+			"",
+		},
+		{
+			map[string]interface{}{
+				"docrn": map[interface{}]interface{}{"pass": true},
+			},
+			`unknown field "docrn", did you mean "docrun"?`,
+		},
+		{
+			map[string]interface{}{
+				"docrun": map[interface{}]interface{}{
+					"test": map[interface{}]interface{}{"call": "transform(ds, ctx)", "expcet": 1},
+				},
+			},
+			`at docrun.test: unknown field "expcet", did you mean "expect"?`,
+		},
+		{
+			map[string]interface{}{
+				"docrun": map[interface{}]interface{}{
+					"save": map[interface{}]interface{}{"zzz": true},
+				},
+			},
+			`at docrun.save: unknown field "zzz", expected one of: append, filename`,
+		},
+	}
+	for i, c := range cases {
+		err := checkFields(c.fields, reflect.TypeOf(DocrunFixture{}))
+		got := ""
+		if err != nil {
+			got = err.Error()
+		}
+		if got != c.expect {
+			t.Errorf("case %d: mismatch, actual: \"%s\", expect: \"%s\"", i, got, c.expect)
+		}
+	}
+}
+
+func TestCheckModes(t *testing.T) {
+	d := docrunDetails{Test: &testDetails{Actual: "ds.get_body()"}}
+	err := d.checkModes()
+	expect := "at test: field \"call\" or \"lifecycle\" is required\nat test: field \"expect\" is required when \"actual\" is set"
+	if err == nil || err.Error() != expect {
+		t.Errorf("mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestCheckModesCompile(t *testing.T) {
+	d := docrunDetails{Compile: &compileDetails{Template: "func", Run: true}}
+	err := d.checkModes()
+	expect := "at compile: a \"func\" template can't be run"
+	if err == nil || err.Error() != expect {
+		t.Errorf("mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestCheckModesCheckImports(t *testing.T) {
+	d := docrunDetails{Check: "syntax", Compile: &compileDetails{Imports: []string{"fmt"}}}
+	if err := d.checkModes(); err != nil {
+		t.Errorf("expected imports to be allowed with check, got: %s", err)
+	}
+	d.Compile.Run = true
+	err := d.checkModes()
+	expect := "at compile: only \"imports\" can be used with \"check\""
+	if err == nil || err.Error() != expect {
+		t.Errorf("mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestCheckModesRoundtrip(t *testing.T) {
+	d := docrunDetails{Filltype: "json", Roundtrip: true}
+	err := d.checkModes()
+	expect := "field \"roundtrip\" can only be used with a \"filltype\" structure"
+	if err == nil || err.Error() != expect {
+		t.Errorf("mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
+
+func TestCheckDatasetRefs(t *testing.T) {
+	err := checkDatasetRefs(map[string]interface{}{"movies": "movies.yaml"})
+	expect := "datasets: invalid reference \"movies\", expected \"peername/name\""
+	if err == nil || err.Error() != expect {
+		t.Errorf("mismatch, actual: \"%v\", expect: \"%s\"", err, expect)
+	}
+}
@@ -0,0 +1,317 @@
+package framework
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/qri-io/qri/base/fill"
+)
+
+// httpMethods are the allowed values for routeDetails.Method, one for each function of the http
+// module
+var httpMethods = []string{"GET", "POST", "PUT", "DELETE", "PATCH", "OPTIONS"}
+
+// checkRequired makes sure the proxy has responses, and that each route can be matched.
+func (p *proxyDetails) checkRequired(collector *fill.ErrorCollector) {
+	if p.URL == "" && len(p.Routes) == 0 && p.Cassette == "" {
+		collector.Add(fmt.Errorf("field \"url\", \"routes\" or \"cassette\" is required"))
+		return
+	}
+	if p.URL != "" && len(p.Routes) > 0 {
+		collector.Add(fmt.Errorf("fields \"url\", \"routes\" are mutually exclusive, only one may be used"))
+		return
+	}
+	for i, route := range p.Routes {
+		collector.PushField(fmt.Sprintf("routes.%d", i))
+		route.checkRequired(collector)
+		collector.PopField()
+	}
+}
+
+// checkRequired makes sure a route matches URLs in exactly one way, and has valid values.
+func (r *routeDetails) checkRequired(collector *fill.ErrorCollector) {
+	matches := []string{}
+	for _, field := range []struct {
+		name  string
+		value string
+	}{{"url", r.URL}, {"prefix", r.Prefix}, {"regex", r.Regex}} {
+		if field.value != "" {
+			matches = append(matches, field.name)
+		}
+	}
+	if len(matches) == 0 {
+		collector.Add(fmt.Errorf("field \"url\", \"prefix\" or \"regex\" is required"))
+	} else if len(matches) > 1 {
+		collector.Add(fmt.Errorf("fields \"%s\" are mutually exclusive, only one may be used",
+			strings.Join(matches, "\", \"")))
+	}
+	if r.Regex != "" {
+		if _, err := regexp.Compile(r.Regex); err != nil {
+			collector.PushField("regex")
+			collector.Add(err)
+			collector.PopField()
+		}
+	}
+	if r.Method != "" && !containsString(httpMethods, strings.ToUpper(r.Method)) {
+		collector.PushField("method")
+		collector.Add(fmt.Errorf("unknown method \"%s\", expected one of: %s", r.Method,
+			strings.Join(httpMethods, ", ")))
+		collector.PopField()
+	}
+	if r.Status != 0 && (r.Status < 100 || r.Status > 599) {
+		collector.PushField("status")
+		collector.Add(fmt.Errorf("invalid status code %d", r.Status))
+		collector.PopField()
+	}
+}
+
+// routes returns the proxy's routes, treating URL and Response as a single route. A query string
+// in the URL of a route becomes query parameters it requires.
+func (p *proxyDetails) routes() []*routeDetails {
+	routes := p.Routes
+	if p.URL != "" {
+		routes = []*routeDetails{{URL: p.URL, Response: p.Response}}
+	}
+	result := make([]*routeDetails, 0, len(routes))
+	for _, route := range routes {
+		u, err := url.Parse(route.URL)
+		if route.URL == "" || err != nil || u.RawQuery == "" {
+			result = append(result, route)
+			continue
+		}
+		r := *route
+		r.Query = map[string]string{}
+		for key := range u.Query() {
+			r.Query[key] = u.Query().Get(key)
+		}
+		for key, val := range route.Query {
+			r.Query[key] = val
+		}
+		u.RawQuery = ""
+		r.URL = u.String()
+		result = append(result, &r)
+	}
+	return result
+}
+
+// matches returns whether the route answers a request.
+func (r *routeDetails) matches(req *http.Request) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, req.Method) {
+		return false
+	}
+	u := *req.URL
+	u.RawQuery = ""
+	u.Fragment = ""
+	address := u.String()
+	switch {
+	case r.URL != "":
+		if address != r.URL {
+			return false
+		}
+	case r.Prefix != "":
+		if !strings.HasPrefix(address, r.Prefix) {
+			return false
+		}
+	case r.Regex != "":
+		if re, err := regexp.Compile(r.Regex); err != nil || !re.MatchString(address) {
+			return false
+		}
+	}
+	query := req.URL.Query()
+	for key, val := range r.Query {
+		if vals, ok := query[key]; !ok || !containsString(vals, val) {
+			return false
+		}
+	}
+	for key, val := range r.RequestHeaders {
+		if req.Header.Get(key) != val {
+			return false
+		}
+	}
+	return true
+}
+
+// String describes the requests the route answers, for error messages
+func (r *routeDetails) String() string {
+	method := "*"
+	if r.Method != "" {
+		method = strings.ToUpper(r.Method)
+	}
+	var target string
+	switch {
+	case r.Prefix != "":
+		target = "prefix " + r.Prefix
+	case r.Regex != "":
+		target = "regex " + r.Regex
+	default:
+		target = r.URL
+	}
+	if len(r.Query) > 0 {
+		values := url.Values{}
+		for key, val := range r.Query {
+			values.Set(key, val)
+		}
+		target += "?" + values.Encode()
+	}
+	return method + " " + target
+}
+
+// writeResponse sends the route's http response.
+func (r *routeDetails) writeResponse(w http.ResponseWriter) error {
+	var body []byte
+	switch v := r.Response.(type) {
+	case nil:
+	case string:
+		body = []byte(v)
+	default:
+		data, err := json.Marshal(jsonCompatible(v))
+		if err != nil {
+			return err
+		}
+		body = data
+		w.Header().Set("Content-Type", "application/json")
+	}
+	for key, val := range r.Headers {
+		w.Header().Set(key, val)
+	}
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	_, err := w.Write(body)
+	return err
+}
+
+// Headers that carry the original URL of a request to the proxy's server, and the reason the
+// server couldn't answer it
+const (
+	proxyURLHeader   = "X-Docrun-Url"
+	proxyErrorHeader = "X-Docrun-Error"
+)
+
+// proxyServer is a local http server that answers requests using the routes of a WebProxy. The
+// first route that matches a request is used, and a request that no route matches is an error.
+type proxyServer struct {
+	routes []*routeDetails
+	server *httptest.Server
+}
+
+// newProxyServer starts a server for the routes.
+func newProxyServer(routes []*routeDetails) *proxyServer {
+	s := &proxyServer{routes: routes}
+	s.server = httptest.NewServer(http.HandlerFunc(s.serve))
+	return s
+}
+
+// match returns the first route that matches a request, if there is one.
+func (s *proxyServer) match(req *http.Request) *routeDetails {
+	for _, route := range s.routes {
+		if route.matches(req) {
+			return route
+		}
+	}
+	return nil
+}
+
+// serve answers a request that was sent to the server in place of its original URL
+func (s *proxyServer) serve(w http.ResponseWriter, r *http.Request) {
+	original, err := url.Parse(r.Header.Get(proxyURLHeader))
+	if err != nil {
+		w.Header().Set(proxyErrorHeader, err.Error())
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	req := *r
+	req.URL = original
+	req.Header = copyHeader(r.Header)
+	req.Header.Del(proxyURLHeader)
+	route := s.match(&req)
+	if route == nil {
+		described := make([]string, len(s.routes))
+		for i, route := range s.routes {
+			described[i] = route.String()
+		}
+		w.Header().Set(proxyErrorHeader, fmt.Sprintf("no WebProxy route matches, expected one of: %s",
+			strings.Join(described, ", ")))
+		w.WriteHeader(http.StatusBadGateway)
+		return
+	}
+	if err := route.writeResponse(w); err != nil {
+		log.Errorf("WebProxy response: %s", err)
+	}
+}
+
+// RoundTrip sends a request to the server instead of its URL, so that the real http client and
+// server handle it, and makes the response appear to have come from the original URL
+func (s *proxyServer) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, err := url.Parse(s.server.URL)
+	if err != nil {
+		return nil, err
+	}
+	address := *req.URL
+	address.Scheme = target.Scheme
+	address.Host = target.Host
+	rewritten := req.WithContext(req.Context())
+	rewritten.URL = &address
+	rewritten.Host = target.Host
+	rewritten.Header = copyHeader(req.Header)
+	rewritten.Header.Set(proxyURLHeader, req.URL.String())
+	res, err := http.DefaultTransport.RoundTrip(rewritten)
+	if err != nil {
+		return nil, err
+	}
+	if reason := res.Header.Get(proxyErrorHeader); reason != "" {
+		res.Body.Close()
+		// The client's error includes the method and URL.
+		return nil, fmt.Errorf("%s", reason)
+	}
+	res.Request = req
+	return res, nil
+}
+
+// Close stops the server
+func (s *proxyServer) Close() {
+	s.server.Close()
+}
+
+// copyHeader returns a copy of a header that can be changed without affecting the original.
+func copyHeader(header http.Header) http.Header {
+	copied := http.Header{}
+	for key, vals := range header {
+		copied[key] = append([]string{}, vals...)
+	}
+	return copied
+}
+
+// caseTransport answers http requests with the WebProxy of the case being run. A session keeps
+// one, so that an http module loaded by an earlier code block uses the current case's WebProxy,
+// rather than one that has already been closed.
+type caseTransport struct {
+	details *testDetails
+}
+
+// RoundTrip sends the request to the case's WebProxy, failing if it doesn't have one
+func (t *caseTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.details == nil || t.details.WebProxy == nil {
+		return errorTransport{}.RoundTrip(req)
+	}
+	transport, err := t.details.WebProxy.transport()
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(req)
+}
+
+// errorTransport fails every request, for tests that don't have a WebProxy
+type errorTransport struct{}
+
+// RoundTrip fails the request
+func (t errorTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return nil, fmt.Errorf("http requires a WebProxy")
+}
@@ -3,11 +3,14 @@ module github.com/qri-io/docrun
 go 1.12
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/gomarkdown/markdown v0.0.0-20190222000725-ee6a7931a1e4
 	github.com/ipfs/go-log v0.0.1
 	github.com/qri-io/dataset v0.1.3-0.20190710190340-f9ddda73d9dd
+	github.com/qri-io/jsonschema v0.1.1
+	github.com/qri-io/qfs v0.1.0
 	github.com/qri-io/qri v0.8.0
 	github.com/qri-io/starlib v0.4.1
-	go.starlark.net v0.0.0-20190604130855-6ddc71c0ba77
+	go.starlark.net v0.0.0-20230302034142-4b1e35fe2254
 	gopkg.in/yaml.v2 v2.2.2
 )
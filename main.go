@@ -6,25 +6,37 @@ import (
 	"flag"
 	"fmt"
 	"os"
+
+	"github.com/qri-io/docrun/framework"
 )
 
 func displayOptions() {
 	fmt.Printf("options:\n")
-	fmt.Printf("   --v    verbose logging\n")
-	fmt.Printf("   --vv   very verbose logging\n")
+	fmt.Printf("   --v                 verbose logging\n")
+	fmt.Printf("   --vv                very verbose logging\n")
+	fmt.Printf("   --config [file]     project configuration, instead of finding %s\n",
+		framework.ConfigFilename)
+	fmt.Printf("   --output [format]   format of results, either \"text\" or \"json\"\n")
+	fmt.Printf("   --run [regexp]      only run cases with an ID matching the expression\n")
+	fmt.Printf("   --tags [list]       only run cases with one of these comma-separated tags\n")
 	fmt.Printf("\n")
 }
 
 func displayCommands() {
 	fmt.Printf("commands:\n")
 	fmt.Printf("   run [filename]   execute docrun on one file\n")
-	fmt.Printf("   report           run over all directories in manifest.txt\n")
+	fmt.Printf("   report           run over all markdown files in the project configuration\n")
 	fmt.Printf("\n")
 }
 
 func main() {
 	verbosePtr := flag.Bool("v", false, "verbose logging to show more info")
 	veryVerbosePtr := flag.Bool("vv", false, "very verbose logging to show debug info")
+	configPtr := flag.String("config", "", "project configuration file")
+	outputPtr := flag.String("output", "", "format of results, either text or json")
+	runPtr := flag.String("run", "", "only run cases with an ID matching the expression")
+	tagsPtr := flag.String("tags", "", "only run cases with one of these comma-separated tags")
+	recordPtr := flag.Bool("record", false, "record http responses from the network into cassettes")
 	flag.Parse()
 
 	if len(flag.Args()) < 1 {
@@ -46,7 +58,30 @@ func main() {
 
 	setLogLevel(logLevel)
 
+	project, err := loadProjectConfig(*configPtr)
+	if err != nil {
+		fmt.Printf("Error loading configuration: %s\n", err)
+		os.Exit(1)
+	}
+	// Command-line flags take precedence over the configuration file.
+	if *outputPtr != "" {
+		project.Output = *outputPtr
+	}
+	runner.Project = project
+
+	filter, err := framework.NewCaseFilter(*runPtr, *tagsPtr)
+	if err != nil {
+		fmt.Printf("Error: %s\n", err)
+		os.Exit(1)
+	}
+	runner.Filter = filter
+	runner.Record = *recordPtr
+
 	if command == "run" {
+		if len(flag.Args()) < 2 {
+			fmt.Printf("Error, run needs a filename\n")
+			os.Exit(1)
+		}
 		filename := flag.Args()[1]
 		docAnalyze(filename)
 	} else if command == "report" {
@@ -58,3 +93,19 @@ func main() {
 		os.Exit(1)
 	}
 }
+
+// loadProjectConfig loads the project configuration, either from the given file or by looking
+// upward from the working directory. If there's no configuration file, defaults are used.
+func loadProjectConfig(filename string) (*framework.ProjectConfig, error) {
+	if filename == "" {
+		found, err := framework.FindProjectConfig(".")
+		if err != nil {
+			return nil, err
+		}
+		if found == "" {
+			return framework.DefaultProjectConfig(), nil
+		}
+		filename = found
+	}
+	return framework.LoadProjectConfig(filename)
+}